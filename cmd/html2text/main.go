@@ -11,10 +11,9 @@ import (
 func main() {
 	reader := bufio.NewReader(os.Stdin)
 	opts := html2text.Options{}
-	out, err := html2text.FromReader(reader, opts)
-	if err != nil {
+	if err := html2text.ToWriter(os.Stdout, reader, opts); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %s\n", err)
 		os.Exit(1)
 	}
-	fmt.Println(out)
+	fmt.Println()
 }
@@ -1,12 +1,19 @@
 package html2text
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/csv"
 	"io"
+	"net/url"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
+	"github.com/mattn/go-runewidth"
 	"github.com/olekukonko/tablewriter"
 	"github.com/ssor/bom"
 	"golang.org/x/net/html"
@@ -15,12 +22,221 @@ import (
 
 // Options provide toggles and overrides to control specific rendering behaviors.
 type Options struct {
-	PrettyTables        bool                 // Turns on pretty ASCII rendering for table elements.
-	PrettyTablesOptions *PrettyTablesOptions // Configures pretty ASCII rendering for table elements.
-	OmitLinks           bool                 // Turns on omitting links
-	TextOnly            bool                 // Returns only plain text
+	PrettyTables        bool                      // Turns on pretty ASCII rendering for table elements.
+	PrettyTablesOptions *PrettyTablesOptions      // Configures pretty ASCII rendering for table elements.
+	OmitLinks           bool                      // Turns on omitting links
+	TextOnly            bool                      // Returns only plain text
+	Format              OutputFormat              // Selects the output markup, plain text by default
+	ListIndent          int                       // Indentation of nested lists, 0 aligns nested items with their parent's text
+	ListBullets         []string                  // Bullets of unordered lists for each nesting level, cycled; "*" ("-" for Markdown) by default
+	LineWidth           int                       // Wraps lines to this many columns, prefixes included; 0 only wraps blockquotes
+	LinkStyle           LinkStyle                 // Selects between inline link targets (default) and numbered references
+	BaseURL             string                    // Resolves relative links against this URL, or the document's <base href> relative to it
+	ElementHandlers     map[string]ElementHandler // Overrides the rendering of elements by tag name, e.g. "x-callout" or "img"
+	HeadingStyles       []HeadingStyle            // Decorations of h1 to h6 in plain text, indexed by level-1; DefaultHeadingStyles() when nil
+	InlineMarkers       *InlineMarkers            // Markers of em, i, u, s, del, ins and mark; DefaultInlineMarkers() when nil
+	CodeMarker          string                    // Surrounds inline code, kbd, samp, tt and var; "`" by default
+	CodeBlockStyle      CodeBlockStyle            // Renders pre blocks as is (default), indented or fenced
+	HorizontalRule      string                    // Repeated across the line width for hr elements; "-" by default
+	CompactDefinitions  int                       // Renders "term: definition" on one line when it fits in this many characters; 0 disables
+	ImageStyle          ImageStyle                // Selects how images outside of links are rendered, omitted by default
+	ImageSources        bool                      // Adds the src of rendered images, following LinkStyle
+	TableFormat         TableFormat               // Renders tables in another format than PrettyTables' ASCII grid
+	TableDelimiter      string                    // Joins the cells of TableDelimited rows, e.g. "\t" or " | "; two spaces by default
+	TableAlignNumbers   bool                      // Pads the cells of TableDelimited tables into columns, right-aligning numeric ones
+	HiddenContent       HiddenContentMode         // Renders (default), drops or only renders elements hidden by attributes or inline styles
+	EmbeddedStyles      bool                      // Applies display, white-space and text-transform rules of <style> elements and style attributes
 }
 
+// ImageStyle selects how images are rendered when they aren't the sole content
+// of a link, in which case their alt text always stands in for the link text.
+// Decorative images, i.e. with an empty alt or a presentation role, are
+// skipped.
+type ImageStyle int
+
+const (
+	// ImageOmit drops images (default).
+	ImageOmit ImageStyle = iota
+	// ImageAltText renders the alt text.
+	ImageAltText
+	// ImageLabeled renders the alt text with a label, e.g. "[image: alt]",
+	// or as an image in Markdown.
+	ImageLabeled
+)
+
+// TableFormat selects how the rows of table elements are laid out.
+type TableFormat int
+
+const (
+	// TableASCII draws an ASCII grid with tablewriter when PrettyTables is
+	// set and runs the cells together as text otherwise (default).
+	TableASCII TableFormat = iota
+	// TableMarkdown renders pipe tables.
+	TableMarkdown
+	// TableCSV renders comma-separated values for downstream parsing.
+	TableCSV
+	// TableTSV renders tab-separated values for downstream parsing.
+	TableTSV
+	// TableUnicode draws a grid with Unicode box-drawing characters.
+	TableUnicode
+	// TableRecords renders each row as "header: value" lines, which suits
+	// narrow screens.
+	TableRecords
+	// TableDelimited renders one line per row, the cells being joined by
+	// Options.TableDelimiter.
+	TableDelimited
+)
+
+// HiddenContentMode selects what becomes of elements which aren't displayed:
+// those with a hidden attribute, aria-hidden="true", or an inline style of
+// display:none, visibility:hidden, font-size:0, or max-height:0 along with
+// overflow:hidden.
+type HiddenContentMode int
+
+const (
+	// HiddenRender renders hidden elements like the others (default).
+	HiddenRender HiddenContentMode = iota
+	// HiddenSkip drops hidden elements along with their content.
+	HiddenSkip
+	// HiddenOnly renders nothing but hidden elements, each as a paragraph of
+	// its own, e.g. to look for text concealed from readers.
+	HiddenOnly
+)
+
+// CodeBlockStyle selects how preformatted blocks are set off from the
+// surrounding text.
+type CodeBlockStyle int
+
+const (
+	// CodeBlockPlain renders the block as is, as a paragraph of its own
+	// (default).  Markdown output falls back to CodeBlockFenced.
+	CodeBlockPlain CodeBlockStyle = iota
+	// CodeBlockIndented indents the block by four spaces.
+	CodeBlockIndented
+	// CodeBlockFenced surrounds the block with ``` fences, along with the
+	// language taken from a "language-xxx" class.
+	CodeBlockFenced
+)
+
+// InlineMarker surrounds the text of an inline formatting element.
+type InlineMarker struct {
+	Open  string
+	Close string
+}
+
+// InlineMarkers configures the markers of inline formatting elements.
+// Elements with a nil marker are rendered without one.
+type InlineMarkers struct {
+	Italic    *InlineMarker // em and i
+	Underline *InlineMarker // u
+	Strike    *InlineMarker // s and strike
+	Deleted   *InlineMarker // del
+	Inserted  *InlineMarker // ins
+	Mark      *InlineMarker // mark
+}
+
+// DefaultInlineMarkers returns the default markers of inline formatting
+// elements in plain text.
+func DefaultInlineMarkers() *InlineMarkers {
+	return &InlineMarkers{
+		Italic:    &InlineMarker{"/", "/"},
+		Underline: &InlineMarker{"_", "_"},
+		Strike:    &InlineMarker{"~", "~"},
+		Deleted:   &InlineMarker{"[-", "-]"},
+		Inserted:  &InlineMarker{"{+", "+}"},
+		Mark:      &InlineMarker{"==", "=="},
+	}
+}
+
+// markdownInlineMarkers are the default markers in Markdown, where underline,
+// insertion and highlighting have no syntax.
+var markdownInlineMarkers = &InlineMarkers{
+	Italic:  &InlineMarker{"_", "_"},
+	Strike:  &InlineMarker{"~~", "~~"},
+	Deleted: &InlineMarker{"~~", "~~"},
+}
+
+// HeadingStyle describes the decoration of a heading level in plain text.
+// Overline and Underline are repeated to the width of the heading.
+type HeadingStyle struct {
+	Overline  string // Divider above the heading, e.g. "*".
+	Underline string // Divider below the heading, e.g. "-".
+	Prefix    string // Marker preceding the heading text, e.g. "== ".
+	Uppercase bool   // Renders the heading text in upper case.
+}
+
+// DefaultHeadingStyles returns the default decorations of h1 to h6.
+func DefaultHeadingStyles() []HeadingStyle {
+	return []HeadingStyle{
+		{Overline: "*", Underline: "*"},
+		{Overline: "-", Underline: "-"},
+		{Underline: "-"},
+		{Underline: "."},
+		{},
+		{},
+	}
+}
+
+// ElementHandler renders an element in place of the built-in rendering of
+// its tag.
+type ElementHandler func(w *ElementWriter, node *html.Node) error
+
+// ElementWriter gives an ElementHandler access to the renderer's output.
+type ElementWriter struct {
+	ctx *textifyTraverseContext
+}
+
+// Emit writes text to the output, following the same spacing and wrapping
+// rules as the built-in rendering.  Text is only separated from the output
+// before it by whitespace in the source or a call to Space.
+func (w *ElementWriter) Emit(text string) error {
+	return w.ctx.emit(text)
+}
+
+// Space separates the output so far from the next one with a space, unless
+// either is whitespace already or the next one starts a line.
+func (w *ElementWriter) Space() {
+	w.ctx.space()
+}
+
+// RenderChildren renders the children of node to the output.
+func (w *ElementWriter) RenderChildren(node *html.Node) error {
+	return w.ctx.traverseChildren(node)
+}
+
+// Default renders node with the built-in rendering of its tag.
+func (w *ElementWriter) Default(node *html.Node) error {
+	return w.ctx.handleElement(node)
+}
+
+// Options returns the options of the rendering in progress.
+func (w *ElementWriter) Options() Options {
+	return w.ctx.options
+}
+
+// OutputFormat selects the flavor of markup emitted by the renderer.
+type OutputFormat int
+
+const (
+	// FormatPlain renders the markdown-flavored plain text form (default).
+	FormatPlain OutputFormat = iota
+	// FormatMarkdown renders CommonMark.
+	FormatMarkdown
+)
+
+// LinkStyle selects how link targets are rendered.
+type LinkStyle int
+
+const (
+	// LinkInline renders the target right after the link text, e.g.
+	// "text ( href )" (default).
+	LinkInline LinkStyle = iota
+	// LinkReferences renders numbered markers, e.g. "text [1]", and lists the
+	// targets in a references section at the end of the output.  Repeated
+	// targets share the same number.
+	LinkReferences
+)
+
 // PrettyTablesOptions overrides tablewriter behaviors
 type PrettyTablesOptions struct {
 	AutoFormatHeader     bool
@@ -39,8 +255,26 @@ type PrettyTablesOptions struct {
 	RowLine              bool
 	AutoMergeCells       bool
 	Borders              tablewriter.Border
+	SpanStyle            SpanStyle        // How cells covered by a colspan or rowspan are filled.
+	NestedTables         NestedTableStyle // Where tables nested in a cell are rendered.
+	DetectLayoutTables   bool             // Renders tables that look like page layout, e.g. in HTML email, as plain text.
+	MaxWidth             int              // Fits tables within this many characters by wrapping cells, or stacks them as records; Options.LineWidth when 0.
 }
 
+// SpanStyle selects how cells spanning several columns or rows are expanded
+// into the rectangular grid of a pretty table.
+type SpanStyle int
+
+const (
+	// SpanBlank leaves the cells covered by a span empty.
+	SpanBlank SpanStyle = iota
+	// SpanRepeat repeats the spanning cell's text in every covered cell.
+	SpanRepeat
+	// SpanMerge repeats the text down a rowspan and lets the table writer
+	// merge the identical cells; cells covered by a colspan are left empty.
+	SpanMerge
+)
+
 // NewPrettyTablesOptions creates PrettyTablesOptions with default settings
 func NewPrettyTablesOptions() *PrettyTablesOptions {
 	return &PrettyTablesOptions{
@@ -65,23 +299,53 @@ func NewPrettyTablesOptions() *PrettyTablesOptions {
 
 // FromHTMLNode renders text output from a pre-parsed HTML document.
 func FromHTMLNode(doc *html.Node, o ...Options) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := render(buf, doc, o...); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ToWriter parses HTML from the specified io.Reader and writes the text form
+// to w as it is rendered, without collecting the whole output in memory.
+func ToWriter(w io.Writer, reader io.Reader, options ...Options) error {
+	newReader, err := bom.NewReaderWithoutBom(reader)
+	if err != nil {
+		return err
+	}
+	doc, err := html.Parse(newReader)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if err := render(bw, doc, options...); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// render writes the text form of a pre-parsed HTML document to w.
+func render(w io.Writer, doc *html.Node, o ...Options) error {
 	var options Options
 	if len(o) > 0 {
 		options = o[0]
 	}
 
 	ctx := textifyTraverseContext{
-		buf:     bytes.Buffer{},
-		options: options,
+		out:           textWriter{w: w},
+		options:       options,
+		endsWithSpace: true,
+		references:    &linkReferences{},
+		baseURL:       documentBaseURL(doc, options.BaseURL),
+		tables:        &tableStack{},
+	}
+	if options.EmbeddedStyles {
+		ctx.styles = parseStylesheets(doc)
 	}
 	if err := ctx.traverse(doc); err != nil {
-		return "", err
+		return err
 	}
-
-	text := strings.TrimSpace(newlineRe.ReplaceAllString(
-		strings.Replace(ctx.buf.String(), "\n ", "\n", -1), "\n\n"),
-	)
-	return text, nil
+	return ctx.emitReferences()
 }
 
 // FromReader renders text output after parsing HTML for the specified
@@ -108,40 +372,380 @@ func FromString(input string, options ...Options) (string, error) {
 	return text, nil
 }
 
-var (
-	spacingRe = regexp.MustCompile(`[ \r\n\t]+`)
-	newlineRe = regexp.MustCompile(`\n\n+`)
-)
+var spacingRe = regexp.MustCompile(`[ \r\n\t]+`)
+
+// textWriter writes rendered text to an io.Writer, or to an internal buffer
+// when w is nil.  Runs of blank lines are collapsed into a single one, and
+// leading newlines as well as trailing whitespace are trimmed on the fly by
+// holding back whitespace until more content follows.  Leading spaces are
+// significant (e.g. aligned list markers), so they are kept.
+type textWriter struct {
+	w       io.Writer
+	buf     bytes.Buffer
+	pending []pendingRune // Whitespace held back until followed by content.
+	started bool          // Whether content has been written.
+	raw     bool          // Set for preformatted text, whose blank lines are kept.
+	scratch [utf8.UTFMax]byte
+}
+
+type pendingRune struct {
+	c   rune
+	raw bool
+}
+
+// WriteRune writes a single rune.
+func (tw *textWriter) WriteRune(c rune) error {
+	if unicode.IsSpace(c) {
+		tw.pending = append(tw.pending, pendingRune{c, tw.raw})
+		return nil
+	}
+
+	var (
+		leading  = !tw.started
+		newlines = 0
+	)
+	for _, p := range tw.pending {
+		if p.c != '\n' {
+			leading = false
+			newlines = 0
+		} else if newlines++; leading || (newlines > 2 && !p.raw) {
+			continue
+		}
+		if err := tw.write(p.c); err != nil {
+			return err
+		}
+	}
+	tw.pending = tw.pending[:0]
+	tw.started = true
+	return tw.write(c)
+}
+
+func (tw *textWriter) write(c rune) error {
+	if tw.w == nil {
+		_, err := tw.buf.WriteRune(c)
+		return err
+	}
+	n := utf8.EncodeRune(tw.scratch[:], c)
+	_, err := tw.w.Write(tw.scratch[:n])
+	return err
+}
+
+// WriteString writes the runes of s.
+func (tw *textWriter) WriteString(s string) error {
+	for _, c := range s {
+		if err := tw.WriteRune(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trimTrailingSpace drops one held back trailing space, if any.
+func (tw *textWriter) trimTrailingSpace() bool {
+	if n := len(tw.pending); n > 0 && tw.pending[n-1].c == ' ' {
+		tw.pending = tw.pending[:n-1]
+		return true
+	}
+	return false
+}
+
+// String returns the text written to the internal buffer.
+func (tw *textWriter) String() string {
+	return tw.buf.String()
+}
 
 // traverseTableCtx holds text-related context.
 type textifyTraverseContext struct {
-	buf bytes.Buffer
+	out textWriter
 
 	prefix          string
-	tableCtx        tableTraverseContext
+	tables          *tableStack
 	options         Options
 	endsWithSpace   bool
 	justClosedDiv   bool
 	blockquoteLevel int
 	lineLength      int
 	isPre           bool
+	lists           []*listTraverseContext
+	pendingPrefix   bool
+	linePrefixLen   int
+	noWrap          bool
+	compactDd       *html.Node
+	references      *linkReferences
+	baseURL         *url.URL
+	inHidden        bool
+	styles          *stylesheet
+	textTransform   string
+	itemStart       bool // A list or definition marker awaits its content on the same line.
+	inCode          bool // Text is literal, e.g. in a Markdown code span.
+	pendingSpace    bool // Whitespace separates the output so far from the next.
+	leadingSpace    bool // Whitespace was due before the first output.
+	emitted         bool
+}
+
+// linkReferences numbers the link targets of a document for
+// options.LinkStyle == LinkReferences.
+type linkReferences struct {
+	hrefs []string
+	index map[string]int
+}
+
+// number returns the reference number of href, allocating a new one for
+// unseen targets.
+func (refs *linkReferences) number(href string) int {
+	if n, ok := refs.index[href]; ok {
+		return n
+	}
+	if refs.index == nil {
+		refs.index = map[string]int{}
+	}
+	refs.hrefs = append(refs.hrefs, href)
+	refs.index[href] = len(refs.hrefs)
+	return len(refs.hrefs)
+}
+
+// listTraverseContext holds the numbering state of a ul or ol element.
+type listTraverseContext struct {
+	ordered bool
+	next    int    // Number assigned to the next list item.
+	step    int    // 1, or -1 for reversed lists.
+	style   string // Numbering type: "1", "a", "A", "i" or "I".
+	width   int    // Width of the widest marker, for alignment.
+	depth   int    // Nesting level, starting at 0.
+
+	itemPrefix    string // Line prefix of the item markers.
+	contentPrefix string // Line prefix of the current item's continuation lines.
+	inItem        bool
+}
+
+// NestedTableStyle selects where tables nested in the cell of another table
+// are rendered.
+type NestedTableStyle int
+
+const (
+	// NestedInline renders a nested table as text inside its cell.
+	NestedInline NestedTableStyle = iota
+	// NestedFlatten leaves the cell empty and renders the nested table on
+	// its own after the outermost table.
+	NestedFlatten
+)
+
+// tableStack holds the tables being collected, innermost last.  It's shared
+// with sub-contexts so tables nested in cells find their enclosing table.
+type tableStack struct {
+	tables []*tableTraverseContext
+}
+
+func (stack *tableStack) push(tableCtx *tableTraverseContext) {
+	stack.tables = append(stack.tables, tableCtx)
+}
+
+func (stack *tableStack) pop() {
+	stack.tables = stack.tables[:len(stack.tables)-1]
+}
+
+// top returns the innermost table being collected, or nil outside tables.
+func (stack *tableStack) top() *tableTraverseContext {
+	if len(stack.tables) == 0 {
+		return nil
+	}
+	return stack.tables[len(stack.tables)-1]
 }
 
 // tableTraverseContext holds table ASCII-form related context.
 type tableTraverseContext struct {
-	header     []string
-	body       [][]string
-	footer     []string
-	tmpRow     int
-	isInFooter bool
+	caption     string
+	head        [][]string
+	body        [][]string
+	foot        [][]string
+	section     tableSection
+	row         []string
+	rowIsHeader bool // Whether every cell of the current row is a th.
+	spanStyle   SpanStyle
+	rowSpans    map[int]rowSpan // Cells spanning into the following rows of the section, by column.
+	flattened   []string        // Nested tables to render after this one.
+	isLayout    bool            // Whether the table is rendered as plain block flow.
+}
+
+// tableSection identifies the thead, tbody or tfoot a row belongs to.
+type tableSection int
+
+const (
+	sectionBody tableSection = iota
+	sectionHead
+	sectionFoot
+)
+
+// rowSpan is the part of a rowspan still to be filled into later rows.
+type rowSpan struct {
+	text string
+	rows int
 }
 
 func (tableCtx *tableTraverseContext) init() {
+	tableCtx.caption = ""
+	tableCtx.head = [][]string{}
 	tableCtx.body = [][]string{}
-	tableCtx.header = []string{}
-	tableCtx.footer = []string{}
-	tableCtx.isInFooter = false
-	tableCtx.tmpRow = 0
+	tableCtx.foot = [][]string{}
+	tableCtx.startSection(sectionBody)
+}
+
+// startSection switches to section; spans never cross section boundaries.
+func (tableCtx *tableTraverseContext) startSection(section tableSection) {
+	tableCtx.section = section
+	tableCtx.rowSpans = map[int]rowSpan{}
+}
+
+func (tableCtx *tableTraverseContext) startRow() {
+	tableCtx.row = []string{}
+	tableCtx.rowIsHeader = true
+}
+
+// appendCell appends text to the current row, expanded over colspan columns,
+// and records any rowspan for the rows that follow.
+func (tableCtx *tableTraverseContext) appendCell(text string, colspan, rowspan int, isHeader bool) {
+	row := tableCtx.fillRowSpans(tableCtx.row, len(tableCtx.row))
+	for i := 0; i < colspan; i++ {
+		cell := text
+		if i > 0 && tableCtx.spanStyle != SpanRepeat {
+			cell = ""
+		}
+		if rowspan > 1 {
+			spanned := cell
+			if tableCtx.spanStyle == SpanBlank {
+				spanned = ""
+			}
+			tableCtx.rowSpans[len(row)] = rowSpan{text: spanned, rows: rowspan - 1}
+		}
+		row = append(row, cell)
+	}
+	tableCtx.row = row
+	tableCtx.rowIsHeader = tableCtx.rowIsHeader && isHeader
+}
+
+// fillRowSpans appends the cells covered by rowspans from earlier rows, up
+// to and including any span starting at column upTo.  Columns between them
+// without a span are padded with empty cells.
+func (tableCtx *tableTraverseContext) fillRowSpans(row []string, upTo int) []string {
+	for {
+		span, ok := tableCtx.rowSpans[len(row)]
+		if !ok {
+			if len(row) >= upTo {
+				return row
+			}
+			row = append(row, "")
+			continue
+		}
+		row = append(row, span.text)
+		if span.rows--; span.rows > 0 {
+			tableCtx.rowSpans[len(row)-1] = span
+		} else {
+			delete(tableCtx.rowSpans, len(row)-1)
+		}
+	}
+}
+
+// endRow fills in the spans reaching past the last cell of the current row
+// and files the row under its section.  A leading body row made of th cells
+// only serves as the header of tables without a thead.
+func (tableCtx *tableTraverseContext) endRow() {
+	last := -1
+	for col := range tableCtx.rowSpans {
+		if col > last {
+			last = col
+		}
+	}
+	row := tableCtx.fillRowSpans(tableCtx.row, last)
+	if len(row) == 0 {
+		return
+	}
+
+	switch {
+	case tableCtx.section == sectionHead:
+		tableCtx.head = append(tableCtx.head, row)
+	case tableCtx.section == sectionFoot:
+		tableCtx.foot = append(tableCtx.foot, row)
+	case tableCtx.rowIsHeader && len(tableCtx.head) == 0 && len(tableCtx.body) == 0:
+		tableCtx.head = append(tableCtx.head, row)
+	default:
+		tableCtx.body = append(tableCtx.body, row)
+	}
+}
+
+// layout arranges the collected rows into a single header and footer row
+// around the body, as expected by tablewriter, and pads every row to the same
+// number of columns.  Extra header and footer rows join the body.
+func (tableCtx *tableTraverseContext) layout() (header []string, body [][]string, footer []string) {
+	if len(tableCtx.head) > 0 {
+		header = tableCtx.head[0]
+		body = append(body, tableCtx.head[1:]...)
+	}
+	body = append(body, tableCtx.body...)
+	if n := len(tableCtx.foot); n > 0 {
+		body = append(body, tableCtx.foot[:n-1]...)
+		footer = tableCtx.foot[n-1]
+	}
+
+	columns := len(header)
+	if len(footer) > columns {
+		columns = len(footer)
+	}
+	for _, row := range body {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	pad := func(row []string) []string {
+		for len(row) > 0 && len(row) < columns {
+			row = append(row, "")
+		}
+		return row
+	}
+	header = pad(header)
+	footer = pad(footer)
+	for i, row := range body {
+		body[i] = pad(row)
+	}
+	return header, body, footer
+}
+
+// cellSpan returns the value of a colspan or rowspan attribute, defaulting
+// to 1.  A rowspan of 0 spans the remaining rows of the row group.
+func cellSpan(node *html.Node, attr string) int {
+	// Guard against absurd spans blowing up the grid, with the limits of
+	// browsers.
+	limit := 1000
+	if attr == "rowspan" {
+		limit = 65534
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(getAttrVal(node, attr)))
+	switch {
+	case err != nil:
+		return 1
+	case n == 0 && attr == "rowspan":
+		return remainingRows(node)
+	case n < 1:
+		return 1
+	case n > limit:
+		return limit
+	}
+	return n
+}
+
+// remainingRows returns the number of rows of the row group from the one
+// holding cell onwards.
+func remainingRows(cell *html.Node) int {
+	tr := cell.Parent
+	if tr == nil || tr.DataAtom != atom.Tr {
+		return 1
+	}
+	n := 1
+	for c := tr.NextSibling; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Tr {
+			n++
+		}
+	}
+	return n
 }
 
 func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
@@ -149,40 +753,72 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 
 	switch node.DataAtom {
 	case atom.Br:
+		if ctx.markdown() && ctx.lineLength > 0 && hasContentAfter(node) {
+			// A bare newline is a soft break in Markdown.
+			return ctx.emit("\\\n")
+		}
 		return ctx.emit("\n")
 
-	case atom.H1, atom.H2, atom.H3:
-		subCtx := textifyTraverseContext{}
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		if ctx.markdown() {
+			return ctx.handleMarkdownHeading(node)
+		}
+		var style HeadingStyle
+		styles := ctx.options.HeadingStyles
+		if styles == nil {
+			styles = DefaultHeadingStyles()
+		}
+		if level := headingLevel(node); level <= len(styles) {
+			style = styles[level-1]
+		}
+
+		// Wrap the heading upfront so that the dividers match the lines.
+		subCtx := ctx.newSubContext()
+		subCtx.options.LineWidth = ctx.availableWidth()
+		if !ctx.options.TextOnly {
+			if style.Uppercase {
+				// Transform the text only, link targets keep their case.
+				subCtx.textTransform = "uppercase"
+			}
+			if err := subCtx.emit(style.Prefix); err != nil {
+				return err
+			}
+		}
 		if err := subCtx.traverseChildren(node); err != nil {
 			return err
 		}
 
-		str := subCtx.buf.String()
+		str := subCtx.out.String()
 		if ctx.options.TextOnly {
 			return ctx.emit(str + ".\n\n")
 		}
 		dividerLen := 0
 		for _, line := range strings.Split(str, "\n") {
-			if lineLen := len([]rune(line)); lineLen-1 > dividerLen {
-				dividerLen = lineLen - 1
+			if lineLen := len([]rune(line)); lineLen > dividerLen {
+				dividerLen = lineLen
 			}
 		}
-		var divider string
-		if node.DataAtom == atom.H1 {
-			divider = strings.Repeat("*", dividerLen)
-		} else {
-			divider = strings.Repeat("-", dividerLen)
-		}
 
-		if node.DataAtom == atom.H3 {
-			return ctx.emit("\n\n" + str + "\n" + divider + "\n\n")
+		heading := "\n\n"
+		if style.Overline != "" {
+			heading += strings.Repeat(style.Overline, dividerLen) + "\n"
 		}
-		return ctx.emit("\n\n" + divider + "\n" + str + "\n" + divider + "\n\n")
+		heading += str + "\n"
+		if style.Underline != "" {
+			heading += strings.Repeat(style.Underline, dividerLen) + "\n"
+		}
+		return ctx.emit(heading + "\n")
 
 	case atom.Blockquote:
 		ctx.blockquoteLevel++
+		prefix := ctx.prefix
 		if !ctx.options.TextOnly {
-			ctx.prefix = strings.Repeat(">", ctx.blockquoteLevel) + " "
+			// Nested blockquotes extend the marker, e.g. ">> ".
+			ctx.prefix = strings.TrimSuffix(prefix, "> ")
+			if ctx.prefix != prefix {
+				ctx.prefix += ">"
+			}
+			ctx.prefix += "> "
 		}
 		if err := ctx.emit("\n"); err != nil {
 			return err
@@ -196,56 +832,47 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 			return err
 		}
 		ctx.blockquoteLevel--
-		if !ctx.options.TextOnly {
-			ctx.prefix = strings.Repeat(">", ctx.blockquoteLevel)
-		}
-		if ctx.blockquoteLevel > 0 {
-			ctx.prefix += " "
-		}
+		ctx.prefix = prefix
 		return ctx.emit("\n\n")
 
 	case atom.Div:
-		if ctx.lineLength > 0 {
-			if err := ctx.emit("\n"); err != nil {
-				return err
-			}
-		}
-		if err := ctx.traverseChildren(node); err != nil {
-			return err
-		}
-		var err error
-		if !ctx.justClosedDiv {
-			err = ctx.emit("\n")
-		}
-		ctx.justClosedDiv = true
-		return err
+		return ctx.divHandler(node)
 
 	case atom.Li:
-		if !ctx.options.TextOnly {
-			if err := ctx.emit("* "); err != nil {
-				return err
-			}
-		}
+		return ctx.handleListItem(node)
 
-		if err := ctx.traverseChildren(node); err != nil {
-			return err
+	case atom.B, atom.Strong:
+		if ctx.isPre && ctx.markdown() {
+			// Markdown code blocks are literal.
+			return ctx.traverseChildren(node)
 		}
+		return ctx.emitInline(node, func(str string) string {
+			if ctx.options.TextOnly {
+				return str + "."
+			}
+			if ctx.markdown() {
+				return "**" + str + "**"
+			}
+			return "*" + str + "*"
+		})
 
-		return ctx.emit("\n")
+	case atom.Em, atom.I, atom.U, atom.S, atom.Strike, atom.Del, atom.Ins, atom.Mark:
+		marker := ctx.inlineMarker(node.DataAtom)
+		if marker == nil || ctx.options.TextOnly || ctx.isPre && ctx.markdown() {
+			return ctx.traverseChildren(node)
+		}
+		return ctx.emitInline(node, func(str string) string {
+			return marker.Open + str + marker.Close
+		})
 
-	case atom.B, atom.Strong:
-		subCtx := textifyTraverseContext{}
-		subCtx.endsWithSpace = true
-		if err := subCtx.traverseChildren(node); err != nil {
-			return err
+	case atom.A:
+		if ctx.isPre && ctx.markdown() {
+			return ctx.traverseChildren(node)
 		}
-		str := subCtx.buf.String()
-		if ctx.options.TextOnly {
-			return ctx.emit(str + ".")
+		if ctx.markdown() {
+			return ctx.handleMarkdownLink(node)
 		}
-		return ctx.emit("*" + str + "*")
 
-	case atom.A:
 		linkText := ""
 		// For simple link element content with single text node only, peek at the link text.
 		if node.FirstChild != nil && node.FirstChild.NextSibling == nil && node.FirstChild.Type == html.TextNode {
@@ -268,157 +895,1719 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 			attrVal = ctx.normalizeHrefLink(attrVal)
 			// Don't print link href if it matches link element content or if the link is empty.
 			if (attrVal != "" && linkText != attrVal) && !ctx.options.OmitLinks && !ctx.options.TextOnly {
-				hrefLink = "( " + attrVal + " )"
+				hrefLink = ctx.linkTarget(attrVal)
 			}
 		}
+		if hrefLink == "" {
+			return nil
+		}
+
+		// Whitespace ending the link text goes after the target.
+		trailingSpace := ctx.pendingSpace
+		ctx.space()
+		if err := ctx.emitUnit(hrefLink); err != nil {
+			return err
+		}
+		if trailingSpace {
+			ctx.space()
+		}
+		return nil
 
-		return ctx.emit(hrefLink)
+	case atom.Ul, atom.Ol:
+		return ctx.handleList(node)
 
-	case atom.P, atom.Ul:
+	case atom.P, atom.Dl:
 		return ctx.paragraphHandler(node)
 
-	case atom.Table, atom.Tfoot, atom.Th, atom.Tr, atom.Td:
-		if ctx.options.PrettyTables {
+	case atom.Dt:
+		return ctx.handleDefinitionTerm(node)
+
+	case atom.Dd:
+		return ctx.handleDefinition(node)
+
+	case atom.Table, atom.Caption, atom.Thead, atom.Tbody, atom.Tfoot, atom.Th, atom.Tr, atom.Td:
+		if ctx.tabular() {
 			return ctx.handleTableElement(node)
-		} else if node.DataAtom == atom.Table {
+		} else if node.DataAtom == atom.Table || node.DataAtom == atom.Caption {
 			return ctx.paragraphHandler(node)
 		}
-		return ctx.traverseChildren(node)
+		// Rows and cells run together, separated by spaces.
+		ctx.space()
+		if err := ctx.traverseChildren(node); err != nil {
+			return err
+		}
+		ctx.space()
+		return nil
+
+	case atom.Img:
+		return ctx.handleImage(node)
+
+	case atom.Hr:
+		if ctx.options.TextOnly {
+			return ctx.emit("\n\n")
+		}
+		rule := "---"
+		if !ctx.markdown() {
+			char := ctx.options.HorizontalRule
+			if char == "" {
+				char = "-"
+			}
+			width := ctx.availableWidth()
+			if width == 0 {
+				width = maxLineLen - utf8.RuneCountInString(ctx.prefix)
+			}
+			rule = strings.Repeat(char, width/utf8.RuneCountInString(char))
+		}
+		return ctx.emitUnwrapped("\n\n" + rule + "\n\n")
 
 	case atom.Pre:
-		ctx.isPre = true
-		err := ctx.traverseChildren(node)
-		ctx.isPre = false
-		return err
+		return ctx.handlePre(node)
+
+	case atom.Code, atom.Kbd, atom.Samp, atom.Var, atom.Tt:
+		if ctx.isPre || ctx.options.TextOnly {
+			return ctx.traverseChildren(node)
+		}
+		inCode := ctx.inCode
+		ctx.inCode = true
+		defer func() { ctx.inCode = inCode }()
+		return ctx.emitInline(node, func(str string) string {
+			marker := ctx.options.CodeMarker
+			if marker == "" {
+				marker = "`"
+			}
+			if ctx.markdown() && strings.Contains(str, "`") {
+				// The code span delimiters must outnumber the backticks inside.
+				marker = strings.Repeat("`", longestRun(str, '`')+1)
+				str = " " + str + " "
+			}
+			return marker + str + marker
+		})
 
 	case atom.Style, atom.Script, atom.Head:
 		// Ignore the subtree.
 		return nil
 
 	default:
+		if blockElements[node.DataAtom] {
+			return ctx.divHandler(node)
+		}
 		return ctx.traverseChildren(node)
 	}
 }
 
-// paragraphHandler renders node children surrounded by double newlines.
-func (ctx *textifyTraverseContext) paragraphHandler(node *html.Node) error {
-	if err := ctx.emit("\n\n"); err != nil {
-		return err
-	}
+// inlineMarker returns the marker of an inline formatting element, or nil when
+// it's rendered without one.
+func (ctx *textifyTraverseContext) inlineMarker(a atom.Atom) *InlineMarker {
+	markers := ctx.options.InlineMarkers
+	if markers == nil {
+		if ctx.markdown() {
+			markers = markdownInlineMarkers
+		} else {
+			markers = DefaultInlineMarkers()
+		}
+	}
+
+	switch a {
+	case atom.Em, atom.I:
+		return markers.Italic
+	case atom.U:
+		return markers.Underline
+	case atom.S, atom.Strike:
+		return markers.Strike
+	case atom.Del:
+		return markers.Deleted
+	case atom.Ins:
+		return markers.Inserted
+	case atom.Mark:
+		return markers.Mark
+	}
+	return nil
+}
+
+// handleImage renders an img element according to options.ImageStyle.
+func (ctx *textifyTraverseContext) handleImage(node *html.Node) error {
+	if ctx.options.ImageStyle == ImageOmit {
+		return nil
+	}
+	switch strings.ToLower(getAttrVal(node, "role")) {
+	case "presentation", "none":
+		return nil
+	}
+	alt := strings.TrimSpace(spacingRe.ReplaceAllString(getAttrVal(node, "alt"), " "))
+	if alt == "" && hasAttr(node, "alt") {
+		return nil
+	}
+
+	var src string
+	if ctx.options.ImageSources && !ctx.options.OmitLinks && !ctx.options.TextOnly {
+		src = ctx.resolveHrefLink(strings.TrimSpace(getAttrVal(node, "src")))
+	}
+
+	if ctx.markdown() && ctx.options.ImageStyle == ImageLabeled && src != "" {
+		alt := escapeMarkdown(alt, false)
+		if ctx.options.LinkStyle == LinkReferences {
+			return ctx.emit("![" + alt + "][" + strconv.Itoa(ctx.references.number(src)) + "]")
+		}
+		return ctx.emit("![" + alt + "](" + markdownDestination(src) + ")")
+	}
+
+	text := alt
+	if ctx.options.ImageStyle == ImageLabeled && !ctx.options.TextOnly {
+		text = "[image"
+		if alt != "" {
+			text += ": " + alt
+		}
+		text += "]"
+	}
+	if ctx.markdown() {
+		text = escapeMarkdown(text, false)
+	}
+	if src == "" {
+		return ctx.emit(text)
+	}
+	if text != "" {
+		if err := ctx.emit(text); err != nil {
+			return err
+		}
+		ctx.space()
+	}
+	return ctx.emitUnit(ctx.linkTarget(src))
+}
+
+// linkTarget renders the target of a link following options.LinkStyle.
+func (ctx *textifyTraverseContext) linkTarget(href string) string {
+	if ctx.options.LinkStyle == LinkReferences {
+		return "[" + strconv.Itoa(ctx.references.number(href)) + "]"
+	}
+	return "( " + href + " )"
+}
+
+// handleDefinitionTerm renders a dt element on a line of its own, or along
+// with its definition when options.CompactDefinitions allows for it.
+func (ctx *textifyTraverseContext) handleDefinitionTerm(node *html.Node) error {
+	if ctx.lineLength > 0 {
+		if err := ctx.emit("\n"); err != nil {
+			return err
+		}
+	}
+
+	if width := ctx.options.CompactDefinitions; width > 0 {
+		dd := nextElementSibling(node)
+		if dd != nil && dd.DataAtom == atom.Dd && (nextElementSibling(dd) == nil || nextElementSibling(dd).DataAtom != atom.Dd) {
+			term, err := ctx.renderInline(node)
+			if err != nil {
+				return err
+			}
+			definition, err := ctx.renderInline(dd)
+			if err != nil {
+				return err
+			}
+			compact := term + ": " + definition
+			if !strings.Contains(compact, "\n") && utf8.RuneCountInString(compact) <= width {
+				ctx.compactDd = dd
+				return ctx.emit(compact + "\n")
+			}
+		}
+	}
+
 	if err := ctx.traverseChildren(node); err != nil {
 		return err
 	}
-	return ctx.emit("\n\n")
+	return ctx.emit("\n")
+}
+
+// handleDefinition renders a dd element indented under its term.
+func (ctx *textifyTraverseContext) handleDefinition(node *html.Node) error {
+	if node == ctx.compactDd {
+		return nil
+	}
+	if ctx.lineLength > 0 {
+		if err := ctx.emit("\n"); err != nil {
+			return err
+		}
+	}
+
+	prefix := ctx.prefix
+	if ctx.markdown() {
+		if err := ctx.emit(": "); err != nil {
+			return err
+		}
+		// Like list items, keep the first block on the marker line.
+		ctx.itemStart = true
+		ctx.prefix += "  "
+	} else if !ctx.options.TextOnly {
+		ctx.prefix += "    "
+	}
+	err := ctx.traverseChildren(node)
+	ctx.itemStart = false
+	ctx.prefix = prefix
+	if err != nil || ctx.lineLength == 0 {
+		return err
+	}
+	return ctx.emit("\n")
+}
+
+// nextElementSibling returns the element following node, if any.
+func nextElementSibling(node *html.Node) *html.Node {
+	for c := node.NextSibling; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			return c
+		}
+	}
+	return nil
+}
+
+// handlePre renders a preformatted block, keeping its whitespace intact, in
+// the configured code block style.
+func (ctx *textifyTraverseContext) handlePre(node *html.Node) error {
+	style := ctx.options.CodeBlockStyle
+	if ctx.options.TextOnly {
+		style = CodeBlockPlain
+	} else if ctx.markdown() && style == CodeBlockPlain {
+		style = CodeBlockFenced
+	}
+
+	opening, fence := "\n\n", ""
+	if style == CodeBlockFenced {
+		fence = codeFence(textContent(node))
+		opening += fence + codeLanguage(node) + "\n"
+	}
+	if err := ctx.emitUnwrapped(opening); err != nil {
+		return err
+	}
+
+	var (
+		prefix = ctx.prefix
+		isPre  = ctx.isPre
+	)
+	if style == CodeBlockIndented {
+		ctx.prefix += "    "
+	}
+	ctx.isPre = true
+	err := ctx.traverseChildren(node)
+	ctx.isPre = isPre
+	ctx.prefix = prefix
+	if err != nil {
+		return err
+	}
+
+	closing := "\n\n"
+	if style == CodeBlockFenced {
+		closing = fence + closing
+		if ctx.lineLength > 0 {
+			closing = "\n" + closing
+		}
+	}
+	return ctx.emitUnwrapped(closing)
+}
+
+// codeLanguage returns the language of a code block, taken from a
+// "language-xxx" or "lang-xxx" class of the pre element or of its code child.
+func codeLanguage(node *html.Node) string {
+	for _, n := range []*html.Node{node, node.FirstChild} {
+		if n == nil || n.Type != html.ElementNode {
+			continue
+		}
+		for _, class := range strings.Fields(getAttrVal(n, "class")) {
+			for _, prefix := range []string{"language-", "lang-"} {
+				if strings.HasPrefix(class, prefix) && len(class) > len(prefix) {
+					return class[len(prefix):]
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// longestRun returns the length of the longest run of c in s.
+func longestRun(s string, c rune) int {
+	longest, n := 0, 0
+	for _, r := range s {
+		if r != c {
+			n = 0
+			continue
+		}
+		if n++; n > longest {
+			longest = n
+		}
+	}
+	return longest
+}
+
+// codeFence returns the backtick fence of a code block holding code, which
+// must be longer than any backtick run of the code.
+func codeFence(code string) string {
+	if n := longestRun(code, '`'); n >= 3 {
+		return strings.Repeat("`", n+1)
+	}
+	return "```"
+}
+
+// textContent returns the concatenated text of the descendants of node.
+func textContent(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+	var text string
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		text += textContent(c)
+	}
+	return text
+}
+
+// hasContentAfter reports whether inline content follows node before the end
+// of its parent or the next line break.
+func hasContentAfter(node *html.Node) bool {
+	for sib := node.NextSibling; sib != nil; sib = sib.NextSibling {
+		switch {
+		case sib.Type == html.TextNode:
+			if strings.TrimSpace(sib.Data) != "" {
+				return true
+			}
+		case sib.Type != html.ElementNode:
+		case sib.DataAtom == atom.Br || blockElements[sib.DataAtom]:
+			return false
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// headingLevel returns the level of an h1-h6 element.
+func headingLevel(node *html.Node) int {
+	return int(node.Data[1] - '0')
+}
+
+// markdown reports whether CommonMark syntax should be emitted.  TextOnly
+// takes precedence over the Markdown output format.
+func (ctx *textifyTraverseContext) markdown() bool {
+	return ctx.options.Format == FormatMarkdown && !ctx.options.TextOnly
+}
+
+// handleMarkdownHeading renders h1-h6 as ATX headings.  Line breaks inside of
+// the heading are folded into spaces since ATX headings are single-line.
+func (ctx *textifyTraverseContext) handleMarkdownHeading(node *html.Node) error {
+	str, err := ctx.renderInline(node)
+	if err != nil {
+		return err
+	}
+
+	// Headings hold a single line, drop the hard breaks.
+	str = strings.Replace(str, "\\\n", "\n", -1)
+	str = strings.Join(strings.Fields(str), " ")
+	if str == "" {
+		return nil
+	}
+	level := headingLevel(node)
+	return ctx.emitUnwrapped("\n\n" + strings.Repeat("#", level) + " " + str + "\n\n")
+}
+
+// handleMarkdownLink renders an anchor as an inline link, or as an autolink
+// when the link text matches the href.
+func (ctx *textifyTraverseContext) handleMarkdownLink(node *html.Node) error {
+	subCtx := ctx.newSubContext()
+	subCtx.options.LineWidth = 0
+
+	// If image is the only child, take its alt text as the link text.
+	if img := node.FirstChild; img != nil && node.LastChild == img && img.DataAtom == atom.Img {
+		if err := subCtx.emit(escapeMarkdown(getAttrVal(img, "alt"), false)); err != nil {
+			return err
+		}
+	} else if err := subCtx.traverseChildren(node); err != nil {
+		return err
+	}
+
+	text := strings.TrimSpace(subCtx.out.String())
+	href := ctx.resolveHrefLink(strings.TrimSpace(getAttrVal(node, "href")))
+	var link string
+	switch {
+	case href == "" || ctx.options.OmitLinks:
+		link = text
+	case text == "" || text == escapeMarkdown(href, false) || text == escapeMarkdown(ctx.normalizeHrefLink(href), false):
+		if strings.Contains(href, ":") {
+			link = "<" + markdownDestination(href) + ">"
+		} else {
+			// Autolinks need a scheme, fall back to an inline link.
+			link = "[" + escapeMarkdown(href, false) + "](" + markdownDestination(href) + ")"
+		}
+	case ctx.options.LinkStyle == LinkReferences:
+		link = "[" + text + "][" + strconv.Itoa(ctx.references.number(href)) + "]"
+	default:
+		link = "[" + text + "](" + markdownDestination(href) + ")"
+	}
+	return ctx.emitFragment(subCtx, link)
+}
+
+var (
+	markdownEscaper  = strings.NewReplacer(`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`, "&", `\&`)
+	markdownMarkerRe = regexp.MustCompile(`^(?:[-+](?:\s|$)|-{3,}|=+(?:\s|$)|>|#{1,6}(?:\s|$)|\d{1,9}[.)](?:\s|$))`)
+)
+
+// escapeMarkdown escapes the characters of text that Markdown would take for
+// formatting, as well as a leading block marker when text starts a line.
+func escapeMarkdown(text string, lineStart bool) string {
+	text = markdownEscaper.Replace(text)
+	if lineStart {
+		text = escapeMarker(text)
+	}
+	return text
+}
+
+// escapeMarker escapes the block marker starting text, if any.
+func escapeMarker(text string) string {
+	marker := markdownMarkerRe.FindString(text)
+	if marker == "" {
+		return text
+	}
+	// Escape the period or parenthesis of ordered list markers.
+	i := strings.IndexAny(marker, ".)")
+	if i < 0 {
+		i = 0
+	}
+	return text[:i] + `\` + text[i:]
+}
+
+var markdownDestinationEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E")
+
+// markdownDestination returns href as a Markdown link destination, with the
+// characters which would end it or let lines break within it percent-encoded.
+func markdownDestination(href string) string {
+	return markdownDestinationEscaper.Replace(href)
+}
+
+// emitReferences renders the references section listing the numbered link
+// targets, or the link reference definitions in Markdown.
+func (ctx *textifyTraverseContext) emitReferences() error {
+	if len(ctx.references.hrefs) == 0 {
+		return nil
+	}
+	ctx.prefix = ""
+	ctx.blockquoteLevel = 0
+
+	if ctx.markdown() {
+		if err := ctx.emit("\n\n"); err != nil {
+			return err
+		}
+	} else if err := ctx.emit("\n\nReferences:\n"); err != nil {
+		return err
+	}
+	for i, href := range ctx.references.hrefs {
+		ref := "[" + strconv.Itoa(i+1) + "]"
+		if ctx.markdown() {
+			ref += ":"
+			href = markdownDestination(href)
+		}
+		if err := ctx.emit(ref + " " + href + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// paragraphHandler renders node children surrounded by double newlines.
+func (ctx *textifyTraverseContext) paragraphHandler(node *html.Node) error {
+	if err := ctx.emit("\n\n"); err != nil {
+		return err
+	}
+	if err := ctx.traverseChildren(node); err != nil {
+		return err
+	}
+	return ctx.emit("\n\n")
+}
+
+// blockElements are the elements laid out on lines of their own, the others
+// flow inline along with the text around them.  Those without a specific
+// rendering are handled like div.
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Center: true, atom.Dd: true, atom.Details: true, atom.Dialog: true,
+	atom.Div: true, atom.Dl: true, atom.Dt: true, atom.Fieldset: true,
+	atom.Figcaption: true, atom.Figure: true, atom.Footer: true, atom.Form: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Header: true, atom.Hgroup: true, atom.Hr: true, atom.Legend: true,
+	atom.Li: true, atom.Main: true, atom.Menu: true, atom.Nav: true,
+	atom.Ol: true, atom.P: true, atom.Pre: true, atom.Section: true,
+	atom.Summary: true, atom.Table: true, atom.Ul: true,
+}
+
+// divHandler renders node as a block on its own lines, without the blank
+// lines around paragraphs.
+func (ctx *textifyTraverseContext) divHandler(node *html.Node) error {
+	if ctx.lineLength > 0 {
+		if err := ctx.emit("\n"); err != nil {
+			return err
+		}
+	}
+	if err := ctx.traverseChildren(node); err != nil {
+		return err
+	}
+	var err error
+	if !ctx.justClosedDiv {
+		err = ctx.emit("\n")
+	}
+	ctx.justClosedDiv = true
+	return err
+}
+
+// handleList renders ul and ol elements.  Lists nested in a list item are
+// indented instead of being set off as a paragraph.
+func (ctx *textifyTraverseContext) handleList(node *html.Node) error {
+	var parent *listTraverseContext
+	if len(ctx.lists) > 0 {
+		parent = ctx.lists[len(ctx.lists)-1]
+	}
+	nested := parent != nil && parent.inItem
+
+	list := ctx.newListTraverseContext(node)
+	list.depth = len(ctx.lists)
+	list.itemPrefix = ctx.prefix
+	if nested && ctx.options.ListIndent > 0 && !ctx.options.TextOnly && ctx.prefix == parent.contentPrefix {
+		list.itemPrefix = parent.itemPrefix + strings.Repeat(" ", ctx.options.ListIndent)
+	}
+
+	prefix := ctx.prefix
+	ctx.prefix = list.itemPrefix
+	ctx.lists = append(ctx.lists, list)
+	defer func() {
+		ctx.lists = ctx.lists[:len(ctx.lists)-1]
+		ctx.prefix = prefix
+	}()
+
+	if !nested {
+		return ctx.paragraphHandler(node)
+	}
+	if ctx.lineLength > 0 {
+		if err := ctx.emit("\n"); err != nil {
+			return err
+		}
+	}
+	return ctx.traverseChildren(node)
+}
+
+// handleListItem renders a li element, indenting its continuation lines
+// under the item text.
+func (ctx *textifyTraverseContext) handleListItem(node *html.Node) error {
+	if ctx.lineLength > 0 {
+		if err := ctx.emit("\n"); err != nil {
+			return err
+		}
+	}
+
+	var marker string
+	if !ctx.options.TextOnly {
+		marker = ctx.listItemMarker(node)
+		if err := ctx.emit(marker); err != nil {
+			return err
+		}
+		// Keep the first block on the marker line, CommonMark list items
+		// can't start with a blank line anyway.
+		ctx.itemStart = true
+	}
+
+	prefix := ctx.prefix
+	ctx.prefix += strings.Repeat(" ", utf8.RuneCountInString(marker))
+	var list *listTraverseContext
+	if len(ctx.lists) > 0 {
+		list = ctx.lists[len(ctx.lists)-1]
+		list.inItem = true
+		list.contentPrefix = ctx.prefix
+	}
+	err := ctx.traverseChildren(node)
+	ctx.itemStart = false
+	if list != nil {
+		list.inItem = false
+	}
+	ctx.prefix = prefix
+	if err != nil {
+		return err
+	}
+
+	if ctx.lineLength > 0 {
+		return ctx.emit("\n")
+	}
+	return nil
+}
+
+// newListTraverseContext prepares the numbering of a list by honoring the
+// start, reversed and type attributes, and the value attribute of each item.
+func (ctx *textifyTraverseContext) newListTraverseContext(node *html.Node) *listTraverseContext {
+	list := &listTraverseContext{
+		ordered: node.DataAtom == atom.Ol,
+		next:    1,
+		step:    1,
+		style:   "1",
+	}
+	if !list.ordered {
+		return list
+	}
+
+	if !ctx.markdown() {
+		// CommonMark only supports arabic numerals.
+		switch style := getAttrVal(node, "type"); style {
+		case "a", "A", "i", "I":
+			list.style = style
+		}
+	}
+
+	var items []*html.Node
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.Li {
+			items = append(items, c)
+		}
+	}
+
+	if hasAttr(node, "reversed") {
+		list.step = -1
+		list.next = len(items)
+	}
+	if start, err := strconv.Atoi(strings.TrimSpace(getAttrVal(node, "start"))); err == nil {
+		list.next = start
+	}
+
+	// Walk the items once upfront so that markers can be right-aligned.
+	n := list.next
+	for _, item := range items {
+		if value, err := strconv.Atoi(strings.TrimSpace(getAttrVal(item, "value"))); err == nil {
+			n = value
+		}
+		if w := len(formatListNumber(n, list.style)) + 1; w > list.width {
+			list.width = w
+		}
+		n += list.step
+	}
+	return list
+}
+
+// listItemMarker returns the bullet or the aligned number which precedes a
+// list item.
+func (ctx *textifyTraverseContext) listItemMarker(node *html.Node) string {
+	var list *listTraverseContext
+	if len(ctx.lists) > 0 {
+		list = ctx.lists[len(ctx.lists)-1]
+	}
+	if list == nil || !list.ordered {
+		bullet := "*"
+		if ctx.markdown() {
+			bullet = "-"
+		}
+		if bullets := ctx.options.ListBullets; len(bullets) > 0 {
+			depth := 0
+			if list != nil {
+				depth = list.depth
+			}
+			bullet = bullets[depth%len(bullets)]
+		}
+		return bullet + " "
+	}
+
+	if value, err := strconv.Atoi(strings.TrimSpace(getAttrVal(node, "value"))); err == nil {
+		list.next = value
+	}
+	marker := formatListNumber(list.next, list.style) + "."
+	list.next += list.step
+	if pad := list.width - len(marker); pad > 0 {
+		marker = strings.Repeat(" ", pad) + marker
+	}
+	return marker + " "
+}
+
+// formatListNumber renders n in the given ol numbering type.  Numbers which
+// can't be represented in the alphabetic or roman types fall back to arabic
+// numerals.
+func formatListNumber(n int, style string) string {
+	switch style {
+	case "a", "A":
+		if n <= 0 {
+			break
+		}
+		var letters []byte
+		for ; n > 0; n = (n - 1) / 26 {
+			letters = append([]byte{byte('a' + (n-1)%26)}, letters...)
+		}
+		if style == "A" {
+			return strings.ToUpper(string(letters))
+		}
+		return string(letters)
+
+	case "i", "I":
+		if n <= 0 || n >= 4000 {
+			break
+		}
+		var roman bytes.Buffer
+		for _, r := range romanNumerals {
+			for ; n >= r.value; n -= r.value {
+				roman.WriteString(r.symbol)
+			}
+		}
+		if style == "I" {
+			return strings.ToUpper(roman.String())
+		}
+		return roman.String()
+	}
+	return strconv.Itoa(n)
+}
+
+var romanNumerals = []struct {
+	value  int
+	symbol string
+}{
+	{1000, "m"}, {900, "cm"}, {500, "d"}, {400, "cd"},
+	{100, "c"}, {90, "xc"}, {50, "l"}, {40, "xl"},
+	{10, "x"}, {9, "ix"}, {5, "v"}, {4, "iv"}, {1, "i"},
+}
+
+// tabular reports whether tables are laid out in rows and columns rather than
+// run together as text.
+func (ctx *textifyTraverseContext) tabular() bool {
+	return ctx.options.PrettyTables || ctx.options.TableFormat != TableASCII
+}
+
+// handleTableElement is only to be invoked when ctx.tabular() is true.
+func (ctx *textifyTraverseContext) handleTableElement(node *html.Node) error {
+	if !ctx.tabular() {
+		panic("handleTableElement invoked when tables aren't laid out")
+	}
+
+	switch node.DataAtom {
+	case atom.Table:
+		tableCtx := &tableTraverseContext{}
+		tableCtx.init()
+		nestedStyle := NestedInline
+		if options := ctx.options.PrettyTablesOptions; options != nil {
+			tableCtx.spanStyle = options.SpanStyle
+			tableCtx.isLayout = options.DetectLayoutTables && isLayoutTable(node)
+			nestedStyle = options.NestedTables
+		}
+		outer := ctx.tables.top()
+
+		if tableCtx.isLayout {
+			ctx.tables.push(tableCtx)
+			err := ctx.paragraphHandler(node)
+			ctx.tables.pop()
+			return err
+		}
+
+		// Browse children, enriching context with table data.
+		ctx.tables.push(tableCtx)
+		err := ctx.traverseChildren(node)
+		ctx.tables.pop()
+		if err != nil {
+			return err
+		}
+
+		rendered, err := ctx.renderTable(tableCtx)
+		if err != nil {
+			return err
+		}
+		if outer != nil && !outer.isLayout && nestedStyle == NestedFlatten {
+			outer.flattened = append(outer.flattened, rendered)
+			outer.flattened = append(outer.flattened, tableCtx.flattened...)
+			return nil
+		}
+
+		for _, table := range append([]string{rendered}, tableCtx.flattened...) {
+			if err := ctx.emit("\n\n"); err != nil {
+				return err
+			}
+			if ctx.markdown() && ctx.options.TableFormat != TableMarkdown {
+				// Markdown would run the lines together, keep them in a code block.
+				fence := codeFence(table)
+				table = fence + "\n" + strings.TrimSuffix(table, "\n") + "\n" + fence
+			}
+			if err := ctx.emitUnwrapped(table); err != nil {
+				return err
+			}
+		}
+		return ctx.emit("\n\n")
+
+	}
+
+	tableCtx := ctx.tables.top()
+	if tableCtx == nil {
+		// Table parts outside of a table, e.g. from an element handler.
+		return ctx.traverseChildren(node)
+	}
+	if tableCtx.isLayout {
+		if node.DataAtom == atom.Td || node.DataAtom == atom.Th || node.DataAtom == atom.Caption {
+			return ctx.divHandler(node)
+		}
+		return ctx.traverseChildren(node)
+	}
+
+	switch node.DataAtom {
+	case atom.Caption:
+		res, err := ctx.renderEachChild(node)
+		if err != nil {
+			return err
+		}
+		tableCtx.caption = res
+
+	case atom.Thead, atom.Tbody, atom.Tfoot:
+		section := sectionBody
+		switch node.DataAtom {
+		case atom.Thead:
+			section = sectionHead
+		case atom.Tfoot:
+			section = sectionFoot
+		}
+		tableCtx.startSection(section)
+		if err := ctx.traverseChildren(node); err != nil {
+			return err
+		}
+		tableCtx.startSection(sectionBody)
+
+	case atom.Tr:
+		tableCtx.startRow()
+		if err := ctx.traverseChildren(node); err != nil {
+			return err
+		}
+		tableCtx.endRow()
+
+	case atom.Th, atom.Td:
+		res, err := ctx.renderEachChild(node)
+		if err != nil {
+			return err
+		}
+		tableCtx.appendCell(res, cellSpan(node, "colspan"), cellSpan(node, "rowspan"), node.DataAtom == atom.Th)
+	}
+	return nil
+}
+
+// isLayoutTable guesses whether a table only arranges page layout, as is
+// common in HTML email, rather than holding tabular data.
+func isLayoutTable(node *html.Node) bool {
+	switch strings.ToLower(getAttrVal(node, "role")) {
+	case "presentation", "none":
+		return true
+	}
+
+	var rows []*html.Node
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		switch c.DataAtom {
+		case atom.Caption, atom.Thead, atom.Tfoot:
+			return false
+		case atom.Tbody:
+			for r := c.FirstChild; r != nil; r = r.NextSibling {
+				if r.DataAtom == atom.Tr {
+					rows = append(rows, r)
+				}
+			}
+		case atom.Tr:
+			rows = append(rows, c)
+		}
+	}
+
+	columns := 0
+	for _, row := range rows {
+		rowColumns := 0
+		for cell := row.FirstChild; cell != nil; cell = cell.NextSibling {
+			switch cell.DataAtom {
+			case atom.Th:
+				return false
+			case atom.Td:
+				rowColumns += cellSpan(cell, "colspan")
+				if hasDescendant(cell, atom.Table) {
+					return true
+				}
+			}
+		}
+		if rowColumns > columns {
+			columns = rowColumns
+		}
+	}
+	if columns <= 1 {
+		return true
+	}
+
+	return strings.TrimSpace(getAttrVal(node, "border")) == "0" &&
+		(hasAttr(node, "cellpadding") || hasAttr(node, "cellspacing"))
+}
+
+// renderTable renders the rows collected in tableCtx following
+// options.TableFormat, titled by the table's caption.
+func (ctx *textifyTraverseContext) renderTable(tableCtx *tableTraverseContext) (string, error) {
+	header, body, footer := tableCtx.layout()
+	buf := &bytes.Buffer{}
+
+	switch ctx.options.TableFormat {
+	case TableCSV, TableTSV:
+		// No caption, which would get in the way of parsing.
+		w := csv.NewWriter(buf)
+		if ctx.options.TableFormat == TableTSV {
+			w.Comma = '\t'
+		}
+		rows := append([][]string{}, body...)
+		if len(header) > 0 {
+			rows = append([][]string{header}, rows...)
+		}
+		if len(footer) > 0 {
+			rows = append(rows, footer)
+		}
+		if err := w.WriteAll(rows); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	if tableCtx.caption != "" {
+		buf.WriteString(tableCtx.caption + "\n")
+		if ctx.options.TableFormat == TableMarkdown {
+			// Keep the caption from running into the table.
+			buf.WriteString("\n")
+		}
+	}
+	format := ctx.options.TableFormat
+	fitted := false
+	if maxWidth := ctx.maxTableWidth(); maxWidth > 0 && (format == TableASCII || format == TableUnicode) {
+		header, body, footer, fitted = fitTable(header, body, footer, maxWidth)
+		if !fitted {
+			// Too narrow for a grid, stack the rows as records instead.
+			records := &bytes.Buffer{}
+			writeRecordTable(records, header, body, footer)
+			buf.WriteString(wrapText(records.String(), maxWidth))
+			return buf.String(), nil
+		}
+	}
+
+	switch format {
+	case TableMarkdown:
+		writeMarkdownTable(buf, header, body, footer)
+	case TableUnicode:
+		writeBoxTable(buf, header, body, footer)
+	case TableRecords:
+		writeRecordTable(buf, header, body, footer)
+	case TableDelimited:
+		delimiter := ctx.options.TableDelimiter
+		if delimiter == "" {
+			delimiter = "  "
+		}
+		writeDelimitedTable(buf, header, body, footer, delimiter, ctx.options.TableAlignNumbers)
+	default:
+		ctx.writeASCIITable(buf, header, body, footer, fitted)
+	}
+	return buf.String(), nil
+}
+
+// maxTableWidth returns the width grid tables must fit in, 0 for no limit.
+func (ctx *textifyTraverseContext) maxTableWidth() int {
+	if options := ctx.options.PrettyTablesOptions; options != nil && options.MaxWidth > 0 {
+		return options.MaxWidth
+	}
+	return ctx.availableWidth()
+}
+
+// fitTable wraps the cells of a grid table so that it's at most maxWidth
+// characters wide, borders included.  Columns get at least the width of their
+// longest word and share the rest by content length.  It returns false when
+// even these minimum widths don't fit.
+func fitTable(header []string, body [][]string, footer []string, maxWidth int) ([]string, [][]string, []string, bool) {
+	rows := append([][]string{header, footer}, body...)
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	natural := make([]int, columns)
+	minimum := make([]int, columns)
+	for _, row := range rows {
+		for i, cell := range row {
+			for _, line := range strings.Split(cell, "\n") {
+				if w := runewidth.StringWidth(line); w > natural[i] {
+					natural[i] = w
+				}
+				for _, word := range strings.Fields(line) {
+					if w := runewidth.StringWidth(word); w > minimum[i] {
+						minimum[i] = w
+					}
+				}
+			}
+		}
+	}
+
+	// Each column takes "| " and " " around its text, plus the closing "|".
+	available := maxWidth - 3*columns - 1
+	naturalSum, minimumSum := 0, 0
+	for i := range natural {
+		naturalSum += natural[i]
+		minimumSum += minimum[i]
+	}
+	if minimumSum > available {
+		return header, body, footer, false
+	}
+	if naturalSum <= available {
+		return header, body, footer, true
+	}
+
+	widths := append([]int{}, minimum...)
+	spare := available - minimumSum
+	slack := naturalSum - minimumSum
+	for i := range widths {
+		extra := spare * (natural[i] - minimum[i]) / slack
+		widths[i] += extra
+		available -= widths[i]
+	}
+	// Hand out what rounding left over, one character at a time.
+	for grown := true; available > 0 && grown; {
+		grown = false
+		for i := range widths {
+			if available > 0 && widths[i] < natural[i] {
+				widths[i]++
+				available--
+				grown = true
+			}
+		}
+	}
+
+	wrapRow := func(row []string) []string {
+		wrapped := make([]string, len(row))
+		for i, cell := range row {
+			wrapped[i] = wrapText(cell, widths[i])
+		}
+		return wrapped
+	}
+	wrappedBody := make([][]string, len(body))
+	for i, row := range body {
+		wrappedBody[i] = wrapRow(row)
+	}
+	return wrapRow(header), wrappedBody, wrapRow(footer), true
+}
+
+// wrapText breaks the lines of text between words so that they're at most
+// width characters wide, except for longer words.
+func wrapText(text string, width int) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		wrapped, lineWidth := "", 0
+		for _, word := range strings.Fields(line) {
+			wordWidth := runewidth.StringWidth(word)
+			switch {
+			case lineWidth == 0:
+				wrapped += word
+				lineWidth = wordWidth
+			case lineWidth+1+wordWidth <= width:
+				wrapped += " " + word
+				lineWidth += 1 + wordWidth
+			default:
+				lines = append(lines, wrapped)
+				wrapped, lineWidth = word, wordWidth
+			}
+		}
+		lines = append(lines, wrapped)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeASCIITable draws an ASCII grid using tablewriter, configured by
+// options.PrettyTablesOptions.  Cells already fitted to the table width
+// aren't wrapped again.
+func (ctx *textifyTraverseContext) writeASCIITable(buf *bytes.Buffer, header []string, body [][]string, footer []string, fitted bool) {
+	table := tablewriter.NewWriter(buf)
+	if ctx.options.PrettyTablesOptions != nil {
+		options := ctx.options.PrettyTablesOptions
+		table.SetAutoFormatHeaders(options.AutoFormatHeader)
+		table.SetAutoWrapText(options.AutoWrapText)
+		table.SetReflowDuringAutoWrap(options.ReflowDuringAutoWrap)
+		table.SetColWidth(options.ColWidth)
+		table.SetColumnSeparator(options.ColumnSeparator)
+		table.SetRowSeparator(options.RowSeparator)
+		table.SetCenterSeparator(options.CenterSeparator)
+		table.SetHeaderAlignment(options.HeaderAlignment)
+		table.SetFooterAlignment(options.FooterAlignment)
+		table.SetAlignment(options.Alignment)
+		table.SetColumnAlignment(options.ColumnAlignment)
+		table.SetNewLine(options.NewLine)
+		table.SetHeaderLine(options.HeaderLine)
+		table.SetRowLine(options.RowLine)
+		table.SetAutoMergeCells(options.AutoMergeCells || options.SpanStyle == SpanMerge)
+		table.SetBorders(options.Borders)
+	}
+	if fitted {
+		table.SetAutoWrapText(false)
+	}
+	table.SetHeader(header)
+	table.SetFooter(footer)
+	table.AppendBulk(body)
+	table.Render()
+}
+
+// writeMarkdownTable renders a pipe table.  Markdown requires a header row, an
+// empty one is made up when the table has none, and the footer joins the body.
+func writeMarkdownTable(buf *bytes.Buffer, header []string, body [][]string, footer []string) {
+	if len(footer) > 0 {
+		body = append(body, footer)
+	}
+	if len(header) == 0 && len(body) > 0 {
+		header = make([]string, len(body[0]))
+	}
+	if len(header) == 0 {
+		return
+	}
+
+	writeRow := func(row []string) {
+		buf.WriteString("|")
+		for _, cell := range row {
+			cell = strings.Replace(strings.Join(strings.Fields(cell), " "), "|", "\\|", -1)
+			buf.WriteString(" " + cell + " |")
+		}
+		buf.WriteString("\n")
+	}
+	writeRow(header)
+	buf.WriteString(strings.Repeat("| --- ", len(header)) + "|\n")
+	for _, row := range body {
+		writeRow(row)
+	}
+}
+
+// writeBoxTable draws a grid with Unicode box-drawing characters.
+func writeBoxTable(buf *bytes.Buffer, header []string, body [][]string, footer []string) {
+	rows := append([][]string{}, body...)
+	if len(header) > 0 {
+		rows = append(rows, header)
+	}
+	if len(footer) > 0 {
+		rows = append(rows, footer)
+	}
+	if len(rows) == 0 {
+		return
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			for _, line := range strings.Split(cell, "\n") {
+				if w := runewidth.StringWidth(line); w > widths[i] {
+					widths[i] = w
+				}
+			}
+		}
+	}
+
+	writeRule := func(left, middle, right string) {
+		buf.WriteString(left)
+		for i, w := range widths {
+			if i > 0 {
+				buf.WriteString(middle)
+			}
+			buf.WriteString(strings.Repeat("─", w+2))
+		}
+		buf.WriteString(right + "\n")
+	}
+	writeRow := func(row []string) {
+		cells := make([][]string, len(row))
+		height := 1
+		for i, cell := range row {
+			cells[i] = strings.Split(cell, "\n")
+			if len(cells[i]) > height {
+				height = len(cells[i])
+			}
+		}
+		for l := 0; l < height; l++ {
+			buf.WriteString("│")
+			for i, lines := range cells {
+				line := ""
+				if l < len(lines) {
+					line = lines[l]
+				}
+				buf.WriteString(" " + runewidth.FillRight(line, widths[i]) + " │")
+			}
+			buf.WriteString("\n")
+		}
+	}
+
+	writeRule("┌", "┬", "┐")
+	if len(header) > 0 {
+		writeRow(header)
+		writeRule("├", "┼", "┤")
+	}
+	for _, row := range body {
+		writeRow(row)
+	}
+	if len(footer) > 0 {
+		writeRule("├", "┼", "┤")
+		writeRow(footer)
+	}
+	writeRule("└", "┴", "┘")
+}
+
+// writeDelimitedTable renders one line per row, joining the cells with
+// delimiter.  With align, cells are padded into columns and the columns
+// holding only numbers in the body are right-aligned.
+func writeDelimitedTable(buf *bytes.Buffer, header []string, body [][]string, footer []string, delimiter string, align bool) {
+	rows := append([][]string{}, body...)
+	bodyStart := 0
+	if len(header) > 0 {
+		rows = append([][]string{header}, rows...)
+		bodyStart = 1
+	}
+	bodyEnd := len(rows)
+	if len(footer) > 0 {
+		rows = append(rows, footer)
+	}
+	if len(rows) == 0 {
+		return
+	}
+	for r, row := range rows {
+		flat := make([]string, len(row))
+		for i, cell := range row {
+			flat[i] = strings.Join(strings.Fields(cell), " ")
+		}
+		rows[r] = flat
+	}
+
+	columns := len(rows[0])
+	widths := make([]int, columns)
+	numeric := make([]bool, columns)
+	if align {
+		for i := range numeric {
+			numeric[i] = true
+			hasNumber := false
+			for r, row := range rows {
+				if w := runewidth.StringWidth(row[i]); w > widths[i] {
+					widths[i] = w
+				}
+				if r < bodyStart || r >= bodyEnd || row[i] == "" {
+					continue
+				}
+				hasNumber = true
+				numeric[i] = numeric[i] && isNumber(row[i])
+			}
+			numeric[i] = numeric[i] && hasNumber
+		}
+	}
+
+	for _, row := range rows {
+		line := ""
+		for i, cell := range row {
+			if i > 0 {
+				line += delimiter
+			}
+			if numeric[i] {
+				cell = runewidth.FillLeft(cell, widths[i])
+			} else if align && i < columns-1 {
+				cell = runewidth.FillRight(cell, widths[i])
+			}
+			line += cell
+		}
+		buf.WriteString(strings.TrimRightFunc(line, unicode.IsSpace) + "\n")
+	}
+}
+
+// isNumber reports whether s reads as a number, allowing for signs, currency
+// symbols, thousands separators and percentages, e.g. "-$1,234.50" or "12%".
+func isNumber(s string) bool {
+	s = strings.TrimLeft(s, "+-−$€£¥")
+	s = strings.TrimRight(s, "%")
+	s = strings.Replace(s, ",", "", -1)
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// writeRecordTable renders every row as a record of "header: value" lines,
+// records being separated by blank lines.  Without a header only the values
+// are listed.  Empty cells are left out.
+func writeRecordTable(buf *bytes.Buffer, header []string, body [][]string, footer []string) {
+	if len(footer) > 0 {
+		body = append(body, footer)
+	}
+	for r, row := range body {
+		if r > 0 {
+			buf.WriteString("\n")
+		}
+		for i, cell := range row {
+			cell = strings.Join(strings.Fields(cell), " ")
+			if cell == "" {
+				continue
+			}
+			if i < len(header) && header[i] != "" {
+				cell = strings.Join(strings.Fields(header[i]), " ") + ": " + cell
+			}
+			buf.WriteString(cell + "\n")
+		}
+	}
+}
+
+func (ctx *textifyTraverseContext) traverse(node *html.Node) error {
+	switch node.Type {
+	default:
+		return ctx.traverseChildren(node)
+
+	case html.TextNode:
+		if ctx.options.HiddenContent == HiddenOnly && !ctx.inHidden {
+			return nil
+		}
+		if ctx.isPre {
+			return ctx.emit(transformText(node.Data, ctx.textTransform))
+		}
+		// Whitespace collapses into a single space between words, which
+		// doesn't survive line starts and ends.
+		data := spacingRe.ReplaceAllString(node.Data, " ")
+		if strings.HasPrefix(data, " ") {
+			ctx.space()
+		}
+		text := transformText(strings.TrimSpace(data), ctx.textTransform)
+		if ctx.markdown() && !ctx.inCode {
+			text = escapeMarkdown(text, ctx.lineLength == 0 || ctx.itemStart)
+			// The following element may render a link, "![" starts an image.
+			next := node.NextSibling
+			if next != nil && next.Type == html.ElementNode && !strings.HasSuffix(data, " ") && strings.HasSuffix(text, "!") {
+				text = strings.TrimSuffix(text, "!") + `\!`
+			}
+		}
+		if err := ctx.emit(text); err != nil {
+			return err
+		}
+		if strings.HasSuffix(data, " ") {
+			ctx.space()
+		}
+		return nil
+
+	case html.ElementNode:
+		style := ctx.styles.computedStyle(node)
+		// Stylesheets hide elements whatever options.HiddenContent says.
+		hidden := style["display"] == "none"
+		if !ctx.inHidden && (hidden || ctx.options.HiddenContent != HiddenRender && isHidden(node)) {
+			if ctx.options.HiddenContent == HiddenOnly {
+				return ctx.handleHidden(node)
+			}
+			return nil
+		}
+		if ctx.options.HiddenContent == HiddenOnly && !ctx.inHidden {
+			// Only look for hidden elements.
+			return ctx.traverseChildren(node)
+		}
+		if style != nil {
+			return ctx.handleStyled(node, style)
+		}
+		return ctx.handleNode(node)
+	}
+}
+
+// handleNode renders an element with its handler from options.ElementHandlers,
+// or the default one.
+func (ctx *textifyTraverseContext) handleNode(node *html.Node) error {
+	if handler, ok := ctx.options.ElementHandlers[node.Data]; ok {
+		ctx.justClosedDiv = false
+		return handler(&ElementWriter{ctx: ctx}, node)
+	}
+	return ctx.handleElement(node)
 }
 
-// handleTableElement is only to be invoked when options.PrettyTables is active.
-func (ctx *textifyTraverseContext) handleTableElement(node *html.Node) error {
-	if !ctx.options.PrettyTables {
-		panic("handleTableElement invoked when PrettyTables not active")
+// handleStyled renders an element following the display, white-space and
+// text-transform properties of its computed style.
+func (ctx *textifyTraverseContext) handleStyled(node *html.Node, style map[string]string) error {
+	isPre, textTransform := ctx.isPre, ctx.textTransform
+	switch style["white-space"] {
+	case "pre", "pre-wrap":
+		ctx.isPre = true
+	case "normal", "nowrap", "pre-line":
+		ctx.isPre = false
+	}
+	if transform, ok := style["text-transform"]; ok {
+		ctx.textTransform = transform
 	}
 
-	switch node.DataAtom {
-	case atom.Table:
-		if err := ctx.emit("\n\n"); err != nil {
-			return err
+	var err error
+	switch {
+	case style["display"] == "block":
+		// Line breaks like a div.
+		if ctx.lineLength > 0 {
+			err = ctx.emit("\n")
+		}
+		if err == nil {
+			err = ctx.handleNode(node)
+		}
+		if err == nil && !ctx.justClosedDiv {
+			err = ctx.emit("\n")
 		}
+		ctx.justClosedDiv = true
+	case style["display"] == "inline" && blockElements[node.DataAtom]:
+		ctx.justClosedDiv = false
+		err = ctx.traverseChildren(node)
+	default:
+		err = ctx.handleNode(node)
+	}
 
-		// Re-intialize all table context.
-		ctx.tableCtx.init()
+	ctx.isPre, ctx.textTransform = isPre, textTransform
+	return err
+}
 
-		// Browse children, enriching context with table data.
-		if err := ctx.traverseChildren(node); err != nil {
-			return err
+// transformText applies a CSS text-transform to text.
+func transformText(text string, transform string) string {
+	switch transform {
+	case "uppercase":
+		return strings.ToUpper(text)
+	case "lowercase":
+		return strings.ToLower(text)
+	case "capitalize":
+		runes := []rune(text)
+		for i, r := range runes {
+			if i == 0 || unicode.IsSpace(runes[i-1]) {
+				runes[i] = unicode.ToUpper(r)
+			}
 		}
+		return string(runes)
+	}
+	return text
+}
 
-		buf := &bytes.Buffer{}
-		table := tablewriter.NewWriter(buf)
-		if ctx.options.PrettyTablesOptions != nil {
-			options := ctx.options.PrettyTablesOptions
-			table.SetAutoFormatHeaders(options.AutoFormatHeader)
-			table.SetAutoWrapText(options.AutoWrapText)
-			table.SetReflowDuringAutoWrap(options.ReflowDuringAutoWrap)
-			table.SetColWidth(options.ColWidth)
-			table.SetColumnSeparator(options.ColumnSeparator)
-			table.SetRowSeparator(options.RowSeparator)
-			table.SetCenterSeparator(options.CenterSeparator)
-			table.SetHeaderAlignment(options.HeaderAlignment)
-			table.SetFooterAlignment(options.FooterAlignment)
-			table.SetAlignment(options.Alignment)
-			table.SetColumnAlignment(options.ColumnAlignment)
-			table.SetNewLine(options.NewLine)
-			table.SetHeaderLine(options.HeaderLine)
-			table.SetRowLine(options.RowLine)
-			table.SetAutoMergeCells(options.AutoMergeCells)
-			table.SetBorders(options.Borders)
-		}
-		table.SetHeader(ctx.tableCtx.header)
-		table.SetFooter(ctx.tableCtx.footer)
-		table.AppendBulk(ctx.tableCtx.body)
+// handleHidden renders a hidden element as a paragraph, for HiddenOnly.
+func (ctx *textifyTraverseContext) handleHidden(node *html.Node) error {
+	if err := ctx.emit("\n\n"); err != nil {
+		return err
+	}
+	ctx.inHidden = true
+	err := ctx.traverse(node)
+	ctx.inHidden = false
+	if err != nil {
+		return err
+	}
+	return ctx.emit("\n\n")
+}
 
-		// Render the table using ASCII.
-		table.Render()
-		if err := ctx.emit(buf.String()); err != nil {
-			return err
+// isHidden reports whether node isn't displayed according to its attributes
+// or inline style.
+func isHidden(node *html.Node) bool {
+	if hasAttr(node, "hidden") || strings.EqualFold(strings.TrimSpace(getAttrVal(node, "aria-hidden")), "true") {
+		return true
+	}
+	style := parseStyle(getAttrVal(node, "style"))
+	switch {
+	case style["display"] == "none":
+		return true
+	case style["visibility"] == "hidden" || style["visibility"] == "collapse":
+		return true
+	case isZeroLength(style["font-size"]):
+		return true
+	case isZeroLength(style["max-height"]) && style["overflow"] == "hidden":
+		return true
+	}
+	return false
+}
+
+// parseStyle parses CSS declarations, e.g. from a style attribute, into a map
+// of lowercased properties to lowercased values.  Later declarations win, and
+// "!important" is dropped.
+func parseStyle(style string) map[string]string {
+	declarations := map[string]string{}
+	for _, declaration := range strings.Split(style, ";") {
+		parts := strings.SplitN(declaration, ":", 2)
+		if len(parts) != 2 {
+			continue
 		}
+		property := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.ToLower(strings.TrimSpace(parts[1]))
+		value = strings.TrimSpace(strings.TrimSuffix(value, "!important"))
+		if property != "" {
+			declarations[property] = value
+		}
+	}
+	return declarations
+}
 
-		return ctx.emit("\n\n")
+// stylesheet holds the rules of the <style> elements of a document.  Only
+// tag, class and id selectors, and descendant combinations of them, are
+// supported; rules with other selectors and at-rules are ignored.
+type stylesheet struct {
+	rules []styleRule
+}
 
-	case atom.Tfoot:
-		ctx.tableCtx.isInFooter = true
-		if err := ctx.traverseChildren(node); err != nil {
-			return err
+// styleRule is a CSS rule with a single selector.
+type styleRule struct {
+	selector     []simpleSelector // Descendant chain, outermost first.
+	specificity  int
+	declarations map[string]string
+}
+
+// simpleSelector matches an element by tag name, id and classes, any of them
+// optional.
+type simpleSelector struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+var (
+	cssCommentRe      = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	simpleSelectorRe  = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9-]*|\*)?((?:[.#][-_a-zA-Z0-9]+)*)$`)
+	selectorPartRe    = regexp.MustCompile(`[.#][-_a-zA-Z0-9]+`)
+	htmlCommentMarker = strings.NewReplacer("<!--", "", "-->", "")
+)
+
+// parseStylesheets collects the rules of every <style> element in doc.
+func parseStylesheets(doc *html.Node) *stylesheet {
+	sheet := &stylesheet{}
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.DataAtom == atom.Style {
+			for c := node.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.TextNode {
+					sheet.parse(c.Data)
+				}
+			}
+			return
 		}
-		ctx.tableCtx.isInFooter = false
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return sheet
+}
 
-	case atom.Tr:
-		ctx.tableCtx.body = append(ctx.tableCtx.body, []string{})
-		if err := ctx.traverseChildren(node); err != nil {
-			return err
+// parse adds the rules of css to the stylesheet.
+func (sheet *stylesheet) parse(css string) {
+	css = htmlCommentMarker.Replace(cssCommentRe.ReplaceAllString(css, ""))
+	for {
+		open := strings.Index(css, "{")
+		if open < 0 {
+			return
 		}
-		ctx.tableCtx.tmpRow++
+		prelude := strings.TrimSpace(css[:open])
 
-	case atom.Th:
-		res, err := ctx.renderEachChild(node)
-		if err != nil {
-			return err
+		// Find the matching brace, skipping over the blocks of at-rules.
+		end, depth := open+1, 1
+		for ; end < len(css) && depth > 0; end++ {
+			switch css[end] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+			}
 		}
+		block := css[open+1 : end-1]
+		if depth > 0 {
+			block = css[open+1:]
+		}
+		css = css[end:]
 
-		ctx.tableCtx.header = append(ctx.tableCtx.header, res)
+		if strings.HasPrefix(prelude, "@") {
+			continue
+		}
+		declarations := parseStyle(block)
+		for _, selector := range strings.Split(prelude, ",") {
+			if rule, ok := parseSelector(selector); ok {
+				rule.declarations = declarations
+				sheet.rules = append(sheet.rules, rule)
+			}
+		}
+	}
+}
 
-	case atom.Td:
-		res, err := ctx.renderEachChild(node)
-		if err != nil {
-			return err
+// parseSelector parses a descendant chain of simple selectors, e.g.
+// "table.footer td".
+func parseSelector(selector string) (styleRule, bool) {
+	var rule styleRule
+	parts := strings.Fields(selector)
+	if len(parts) == 0 {
+		return rule, false
+	}
+	for _, part := range parts {
+		m := simpleSelectorRe.FindStringSubmatch(part)
+		if m == nil || m[1] == "" && m[2] == "" {
+			return rule, false
 		}
+		simple := simpleSelector{}
+		if m[1] != "" && m[1] != "*" {
+			simple.tag = strings.ToLower(m[1])
+			rule.specificity++
+		}
+		for _, sub := range selectorPartRe.FindAllString(m[2], -1) {
+			if sub[0] == '#' {
+				simple.id = sub[1:]
+				rule.specificity += 100
+			} else {
+				simple.classes = append(simple.classes, sub[1:])
+				rule.specificity += 10
+			}
+		}
+		rule.selector = append(rule.selector, simple)
+	}
+	return rule, true
+}
 
-		if ctx.tableCtx.isInFooter {
-			ctx.tableCtx.footer = append(ctx.tableCtx.footer, res)
-		} else {
-			ctx.tableCtx.body[ctx.tableCtx.tmpRow] = append(ctx.tableCtx.body[ctx.tableCtx.tmpRow], res)
+// matches reports whether node is an element matched by simple.
+func (simple simpleSelector) matches(node *html.Node) bool {
+	if node.Type != html.ElementNode {
+		return false
+	}
+	if simple.tag != "" && simple.tag != node.Data {
+		return false
+	}
+	if simple.id != "" && simple.id != getAttrVal(node, "id") {
+		return false
+	}
+	classes := strings.Fields(getAttrVal(node, "class"))
+	for _, class := range simple.classes {
+		found := false
+		for _, c := range classes {
+			if c == class {
+				found = true
+				break
+			}
 		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
 
+// matches reports whether the rule applies to node.
+func (rule styleRule) matches(node *html.Node) bool {
+	last := len(rule.selector) - 1
+	if !rule.selector[last].matches(node) {
+		return false
 	}
-	return nil
+	i := last - 1
+	for ancestor := node.Parent; ancestor != nil && i >= 0; ancestor = ancestor.Parent {
+		if rule.selector[i].matches(ancestor) {
+			i--
+		}
+	}
+	return i < 0
 }
 
-func (ctx *textifyTraverseContext) traverse(node *html.Node) error {
-	switch node.Type {
-	default:
-		return ctx.traverseChildren(node)
+// computedStyle returns the declarations applying to node: those of the
+// matching rules by increasing specificity, then those of its style
+// attribute.  It returns nil without a stylesheet.
+func (sheet *stylesheet) computedStyle(node *html.Node) map[string]string {
+	if sheet == nil {
+		return nil
+	}
+	var matched []styleRule
+	for _, rule := range sheet.rules {
+		if rule.matches(node) {
+			matched = append(matched, rule)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].specificity < matched[j].specificity
+	})
 
-	case html.TextNode:
-		var data string
-		if ctx.isPre {
-			data = node.Data
-		} else {
-			data = strings.TrimSpace(spacingRe.ReplaceAllString(node.Data, " "))
+	style := map[string]string{}
+	for _, rule := range matched {
+		for property, value := range rule.declarations {
+			style[property] = value
 		}
-		return ctx.emit(data)
+	}
+	for property, value := range parseStyle(getAttrVal(node, "style")) {
+		style[property] = value
+	}
+	return style
+}
 
-	case html.ElementNode:
-		return ctx.handleElement(node)
+// isZeroLength reports whether a CSS length, e.g. "0", "0px" or "0.0em", is
+// zero.
+func isZeroLength(value string) bool {
+	number := strings.TrimRightFunc(value, func(r rune) bool {
+		return unicode.IsLetter(r) || r == '%'
+	})
+	if number == "" {
+		return false
 	}
+	f, err := strconv.ParseFloat(number, 64)
+	return err == nil && f == 0
 }
 
 func (ctx *textifyTraverseContext) traverseChildren(node *html.Node) error {
@@ -431,47 +2620,256 @@ func (ctx *textifyTraverseContext) traverseChildren(node *html.Node) error {
 	return nil
 }
 
+// space separates the output so far from the next one with a space, unless
+// one of them is whitespace already, e.g. for whitespace between inline
+// elements in the source.
+func (ctx *textifyTraverseContext) space() {
+	if !ctx.emitted {
+		ctx.leadingSpace = true
+	}
+	ctx.pendingSpace = true
+}
+
+// takeSpace reports whether a space is due before the output being emitted.
+func (ctx *textifyTraverseContext) takeSpace() bool {
+	space := ctx.pendingSpace
+	ctx.pendingSpace = false
+	ctx.emitted = true
+	return space
+}
+
 func (ctx *textifyTraverseContext) emit(data string) error {
+	if ctx.itemStart {
+		data = strings.TrimLeft(data, "\n")
+		ctx.itemStart = data == ""
+	}
 	if data == "" {
 		return nil
 	}
+	if ctx.options.LineWidth > 0 && !ctx.isPre && !ctx.noWrap {
+		return ctx.emitWrapped(data)
+	}
 	var (
 		lines = ctx.breakLongLines(data)
 		err   error
 	)
+	space := ctx.takeSpace()
 	for _, line := range lines {
 		runes := []rune(line)
 		startsWithSpace := unicode.IsSpace(runes[0])
-		if !startsWithSpace && !ctx.endsWithSpace && !strings.HasPrefix(data, ".") {
-			if err = ctx.buf.WriteByte(' '); err != nil {
+		if space && !startsWithSpace && !ctx.endsWithSpace && !ctx.isPre {
+			if err = ctx.writeRune(' '); err != nil {
 				return err
 			}
-			ctx.lineLength++
 		}
+		space = false
 		ctx.endsWithSpace = unicode.IsSpace(runes[len(runes)-1])
 		for _, c := range line {
-			if _, err = ctx.buf.WriteString(string(c)); err != nil {
+			if err = ctx.writeRune(c); err != nil {
 				return err
 			}
-			ctx.lineLength++
-			if c == '\n' {
-				ctx.lineLength = 0
-				if ctx.prefix != "" {
-					if _, err = ctx.buf.WriteString(ctx.prefix); err != nil {
-						return err
-					}
+		}
+	}
+	return nil
+}
+
+// emitUnwrapped emits data which must not be broken across lines, such as
+// pre-rendered tables.
+func (ctx *textifyTraverseContext) emitUnwrapped(data string) error {
+	noWrap := ctx.noWrap
+	ctx.noWrap = true
+	err := ctx.emit(data)
+	ctx.noWrap = noWrap
+	return err
+}
+
+// emitUnit emits data which must stay on a single line, e.g. a link target,
+// breaking the line beforehand when it doesn't fit.
+func (ctx *textifyTraverseContext) emitUnit(data string) error {
+	if data == "" || ctx.options.LineWidth <= 0 || ctx.isPre || ctx.noWrap {
+		return ctx.emit(data)
+	}
+	ctx.itemStart = false
+	sep := 0
+	if ctx.takeSpace() && !ctx.endsWithSpace {
+		sep = 1
+	}
+	ctx.endsWithSpace = false
+	return ctx.writeWord([]rune(data), sep, false)
+}
+
+// emitWrapped emits data, breaking lines at word boundaries so that they fit
+// in options.LineWidth.  Words are only split when they can't fit on a line by
+// themselves, and never in Markdown.
+func (ctx *textifyTraverseContext) emitWrapped(data string) error {
+	runes := []rune(data)
+	sep := 0
+	if ctx.takeSpace() && !unicode.IsSpace(runes[0]) && !ctx.endsWithSpace {
+		sep = 1
+	}
+	ctx.endsWithSpace = unicode.IsSpace(runes[len(runes)-1])
+
+	for i := 0; i < len(runes); {
+		switch c := runes[i]; {
+		case c == '\n':
+			if err := ctx.writeRune(c); err != nil {
+				return err
+			}
+			sep = 0
+			i++
+
+		case unicode.IsSpace(c):
+			j := i
+			for j < len(runes) && runes[j] != '\n' && unicode.IsSpace(runes[j]) {
+				j++
+			}
+			if j == len(runes) {
+				// Trailing whitespace, e.g. of a list marker.
+				if err := ctx.writeString(string(runes[i:j])); err != nil {
+					return err
 				}
+			} else {
+				sep = j - i
+			}
+			i = j
+
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) {
+				j++
+			}
+			if err := ctx.writeWord(runes[i:j], sep, !ctx.markdown()); err != nil {
+				return err
 			}
+			sep = 0
+			i = j
+		}
+	}
+	return nil
+}
+
+// writeWord writes word preceded by sep spaces, breaking the line beforehand
+// when it doesn't fit.  Words too long for a line by themselves are split when
+// split is set.
+func (ctx *textifyTraverseContext) writeWord(word []rune, sep int, split bool) error {
+	var (
+		width = ctx.options.LineWidth
+		start = runewidth.StringWidth(ctx.prefix)
+	)
+	if ctx.column()+sep+runewidth.StringWidth(string(word)) > width && ctx.column() > start {
+		if err := ctx.breakLine(); err != nil {
+			return err
+		}
+		sep = 0
+		if ctx.markdown() {
+			// Don't let the word turn the new line into a list item, a
+			// heading, etc.
+			word = []rune(escapeMarker(string(word)))
+		}
+	}
+	if err := ctx.writeString(strings.Repeat(" ", sep)); err != nil {
+		return err
+	}
+	for split && len(word) > 0 {
+		n, fill := 0, 0
+		for n < len(word) && ctx.column()+fill+runewidth.RuneWidth(word[n]) <= width {
+			fill += runewidth.RuneWidth(word[n])
+			n++
+		}
+		if n == len(word) {
+			break
+		}
+		if n < 1 {
+			n = 1
+		}
+		if err := ctx.writeString(string(word[:n])); err != nil {
+			return err
+		}
+		if err := ctx.breakLine(); err != nil {
+			return err
 		}
+		word = word[n:]
 	}
+	return ctx.writeString(string(word))
+}
+
+// breakLine starts a new line, dropping trailing spaces of the current one.
+func (ctx *textifyTraverseContext) breakLine() error {
+	for ctx.lineLength > 0 && ctx.out.trimTrailingSpace() {
+		ctx.lineLength--
+	}
+	return ctx.writeRune('\n')
+}
+
+// column returns the width of the current line, including its prefix.
+func (ctx *textifyTraverseContext) column() int {
+	if ctx.pendingPrefix {
+		return runewidth.StringWidth(ctx.prefix) + ctx.lineLength
+	}
+	return ctx.linePrefixLen + ctx.lineLength
+}
+
+// availableWidth returns the line width left after the current prefix, or 0
+// when lines aren't wrapped.
+func (ctx *textifyTraverseContext) availableWidth() int {
+	if ctx.options.LineWidth <= 0 {
+		return 0
+	}
+	if width := ctx.options.LineWidth - runewidth.StringWidth(ctx.prefix); width > 0 {
+		return width
+	}
+	return 1
+}
+
+func (ctx *textifyTraverseContext) writeString(s string) error {
+	for _, c := range s {
+		if err := ctx.writeRune(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRune writes c to the output and takes care of line prefixes.  Prefixes
+// made only of whitespace (i.e. list indentation) are deferred until the line
+// receives content, so that blank lines stay empty and pick up the prefix in
+// effect at that point.
+func (ctx *textifyTraverseContext) writeRune(c rune) error {
+	ctx.out.raw = ctx.isPre
+	if ctx.pendingPrefix && c != '\n' {
+		ctx.pendingPrefix = false
+		if err := ctx.writePrefix(); err != nil {
+			return err
+		}
+	}
+	if err := ctx.out.WriteRune(c); err != nil {
+		return err
+	}
+	ctx.lineLength += runewidth.RuneWidth(c)
+	if c == '\n' {
+		ctx.lineLength = 0
+		ctx.linePrefixLen = 0
+		ctx.pendingPrefix = strings.TrimSpace(ctx.prefix) == ""
+		if !ctx.pendingPrefix {
+			return ctx.writePrefix()
+		}
+	}
+	return nil
+}
+
+func (ctx *textifyTraverseContext) writePrefix() error {
+	if err := ctx.out.WriteString(ctx.prefix); err != nil {
+		return err
+	}
+	ctx.linePrefixLen = runewidth.StringWidth(ctx.prefix)
 	return nil
 }
 
 const maxLineLen = 74
 
 func (ctx *textifyTraverseContext) breakLongLines(data string) []string {
-	// Only break lines when in blockquotes.
-	if ctx.blockquoteLevel == 0 {
+	// Without a configured line width, only break lines when in blockquotes.
+	if ctx.blockquoteLevel == 0 || ctx.noWrap || ctx.isPre {
 		return []string{data}
 	}
 	var (
@@ -511,30 +2909,186 @@ func (ctx *textifyTraverseContext) breakLongLines(data string) []string {
 }
 
 func (ctx *textifyTraverseContext) normalizeHrefLink(link string) string {
-	link = strings.TrimSpace(link)
+	link = ctx.resolveHrefLink(strings.TrimSpace(link))
 	link = strings.TrimPrefix(link, "mailto:")
 	return link
 }
 
-// renderEachChild visits each direct child of a node and collects the sequence of
-// textuual representaitons separated by a single newline.
+// resolveHrefLink makes link absolute using the base URL, if any.  Links which
+// fail to parse are returned unchanged.
+func (ctx *textifyTraverseContext) resolveHrefLink(link string) string {
+	if ctx.baseURL == nil || link == "" {
+		return link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return ctx.baseURL.ResolveReference(u).String()
+}
+
+// documentBaseURL determines the URL which relative links are resolved
+// against: the href of the document's first base element, itself resolved
+// against baseURL.  Returns nil when neither is available, an invalid baseURL
+// is ignored like an invalid base href.
+func documentBaseURL(doc *html.Node, baseURL string) *url.URL {
+	var base *url.URL
+	if baseURL != "" {
+		if u, err := url.Parse(baseURL); err == nil {
+			base = u
+		}
+	}
+
+	if node := findElement(doc, atom.Base, "href"); node != nil {
+		if u, err := url.Parse(strings.TrimSpace(getAttrVal(node, "href"))); err == nil {
+			if base != nil {
+				u = base.ResolveReference(u)
+			}
+			if u.IsAbs() {
+				base = u
+			}
+		}
+	}
+	return base
+}
+
+// findElement returns the first element of type a in the subtree which has the
+// specified attribute.
+func findElement(node *html.Node, a atom.Atom, attrName string) *html.Node {
+	if node.Type == html.ElementNode && node.DataAtom == a && hasAttr(node, attrName) {
+		return node
+	}
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a, attrName); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// hasDescendant reports whether an element a is found below node.
+func hasDescendant(node *html.Node, a atom.Atom) bool {
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == a || hasDescendant(c, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// newSubContext returns a context for rendering a fragment of the document on
+// its own, e.g. to decorate or measure it, which shares the options and the
+// document-wide state of ctx.
+func (ctx *textifyTraverseContext) newSubContext() *textifyTraverseContext {
+	return &textifyTraverseContext{
+		options:       ctx.options,
+		endsWithSpace: true,
+		isPre:         ctx.isPre,
+		references:    ctx.references,
+		baseURL:       ctx.baseURL,
+		tables:        ctx.tables,
+		inHidden:      ctx.inHidden,
+		styles:        ctx.styles,
+		textTransform: ctx.textTransform,
+		inCode:        ctx.inCode,
+	}
+}
+
+// renderInline renders the children of node as an inline fragment.  The
+// fragment isn't wrapped, the parent context takes care of it once emitted.
+func (ctx *textifyTraverseContext) renderInline(node *html.Node) (string, error) {
+	subCtx, err := ctx.renderInlineContext(node)
+	if err != nil {
+		return "", err
+	}
+	return subCtx.out.String(), nil
+}
+
+// emitInline renders the children of node as an inline fragment, decorated by
+// decorate, e.g. to add markers around it.
+func (ctx *textifyTraverseContext) emitInline(node *html.Node, decorate func(string) string) error {
+	subCtx, err := ctx.renderInlineContext(node)
+	if err != nil {
+		return err
+	}
+	if str := subCtx.out.String(); str != "" {
+		return ctx.emitFragment(subCtx, decorate(str))
+	}
+	return ctx.emitFragment(subCtx, "")
+}
+
+// emitFragment emits data, rendered from subCtx, keeping the whitespace found
+// at the start and the end of the fragment around it.
+func (ctx *textifyTraverseContext) emitFragment(subCtx *textifyTraverseContext, data string) error {
+	if subCtx.leadingSpace {
+		ctx.space()
+	}
+	if err := ctx.emit(data); err != nil {
+		return err
+	}
+	if subCtx.pendingSpace {
+		ctx.space()
+	}
+	return nil
+}
+
+// renderInlineContext renders the children of node in a sub-context, which
+// is returned for its output and the whitespace around it.
+func (ctx *textifyTraverseContext) renderInlineContext(node *html.Node) (*textifyTraverseContext, error) {
+	subCtx := ctx.newSubContext()
+	subCtx.options.LineWidth = 0
+	if err := subCtx.traverseChildren(node); err != nil {
+		return nil, err
+	}
+	return subCtx, nil
+}
+
+// renderEachChild visits the direct children of a node and collects the
+// sequence of textual representations separated by a single newline.  Block
+// elements are rendered on their own, runs of text and inline elements
+// together.
 func (ctx *textifyTraverseContext) renderEachChild(node *html.Node) (string, error) {
-	buf := &bytes.Buffer{}
+	var parts []string
+	var subCtx *textifyTraverseContext
+	flush := func() {
+		if subCtx != nil {
+			if str := subCtx.out.String(); str != "" {
+				parts = append(parts, str)
+			}
+			subCtx = nil
+		}
+	}
 	for c := node.FirstChild; c != nil; c = c.NextSibling {
-		s, err := FromHTMLNode(c, ctx.options)
-		if err != nil {
-			return "", err
+		block := c.Type == html.ElementNode && blockElements[c.DataAtom]
+		if block {
+			flush()
 		}
-		if _, err = buf.WriteString(s); err != nil {
+		if subCtx == nil {
+			// Cells are laid out by the table, not wrapped to the page.
+			subCtx = ctx.newSubContext()
+			subCtx.options.LineWidth = 0
+			// Tables other than Markdown ones end up in code blocks.
+			subCtx.inCode = ctx.markdown() && ctx.options.TableFormat != TableMarkdown
+		}
+		if err := subCtx.traverse(c); err != nil {
 			return "", err
 		}
-		if c.NextSibling != nil {
-			if err = buf.WriteByte('\n'); err != nil {
-				return "", err
-			}
+		if block {
+			flush()
 		}
 	}
-	return buf.String(), nil
+	flush()
+	return strings.Join(parts, "\n"), nil
+}
+
+func hasAttr(node *html.Node, attrName string) bool {
+	for _, attr := range node.Attr {
+		if attr.Key == attrName {
+			return true
+		}
+	}
+
+	return false
 }
 
 func getAttrVal(node *html.Node, attrName string) string {
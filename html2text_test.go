@@ -9,6 +9,8 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+
+	"golang.org/x/net/html"
 )
 
 const destPath = "testdata"
@@ -62,6 +64,46 @@ func TestParseUTF8(t *testing.T) {
 	}
 }
 
+func TestToWriter(t *testing.T) {
+	inputs := []string{
+		"",
+		"Test text",
+		"\n<p>Test text</p>\n\n\n\t<p>Test text</p>\n",
+		"<blockquote>Test</blockquote> <blockquote>Test</blockquote> Other Test",
+		"<ul><li>item 1<ul><li>item 1.1</li></ul></li><li>item 2</li></ul>",
+		"<p>Lorem ipsum <a href=\"http://example.com/\">dolor</a> sit amet.</p>",
+	}
+
+	for _, input := range inputs {
+		options := Options{LinkStyle: LinkReferences}
+		expected, err := FromString(input, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		if err := ToWriter(buf, strings.NewReader(input), options); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expected {
+			t.Errorf("ToWriter output for input %q was %q, expected %q", input, buf.String(), expected)
+		}
+	}
+
+	bs, err := ioutil.ReadFile(path.Join(destPath, "utf8_with_bom.xhtml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ToWriter(failingWriter{}, bytes.NewReader(bs)); err == nil {
+		t.Error("expected ToWriter to return the write error")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, fmt.Errorf("write failed")
+}
+
 func TestStrippingWhitespace(t *testing.T) {
 	testCases := []struct {
 		input  string
@@ -330,6 +372,40 @@ Table 2 Header 1 Table 2 Header 2 Table 2 Footer 1 Table 2 Footer 2 Table 2 Row
 +--------+--------------------------------+--------+`,
 			"Item Description Price Golang Open source programming language that makes it easy to build simple, reliable, and efficient software $10.99 Hermes Programmatically create beautiful e-mails using Golang. $1.99",
 		},
+		{
+			`<table>
+				<caption>Staff</caption>
+				<thead><tr><th>Name</th><th>Role</th></tr></thead>
+				<tbody>
+					<tr><th>Ann</th><td>Dev</td></tr>
+					<tr><th>Bob</th><td>Ops</td></tr>
+				</tbody>
+			</table>`,
+			`Staff
++------+------+
+| NAME | ROLE |
++------+------+
+| Ann  | Dev  |
+| Bob  | Ops  |
++------+------+`,
+			"Staff\n\nName Role Ann Dev Bob Ops",
+		},
+		{
+			`<table>
+				<tr><th>Name</th><td>Ann</td></tr>
+				<tr><th>Role</th><td>Dev</td></tr>
+			</table>`,
+			`+------+-----+
+| Name | Ann |
+| Role | Dev |
++------+-----+`,
+			"Name Ann Role Dev",
+		},
+		{
+			"<table><tr><td>Hello <b>World</b></td><td><p>A</p> <p>B</p></td></tr></table>",
+			"+---------------+---+\n| Hello *World* | A |\n|               | B |\n+---------------+---+",
+			"Hello *World*\n\nA\n\nB",
+		},
 	}
 
 	for _, testCase := range testCases {
@@ -353,31 +429,80 @@ Table 2 Header 1 Table 2 Header 2 Table 2 Footer 1 Table 2 Footer 2 Table 2 Row
 	}
 }
 
-func TestStrippingLists(t *testing.T) {
+func TestTableSpans(t *testing.T) {
+	const schedule = `<table>
+		<tr><th>Day</th><th colspan="2">Slot</th></tr>
+		<tr><td rowspan="2">Mon</td><td>9am</td><td>Math</td></tr>
+		<tr><td>10am</td><td>Art</td></tr>
+		<tr><td>Tue</td><td colspan="2">Holiday</td></tr>
+	</table>`
+
 	testCases := []struct {
-		input  string
-		output string
+		input     string
+		output    string
+		spanStyle SpanStyle
 	}{
 		{
-			"<ul></ul>",
-			"",
+			schedule,
+			`+-----+---------+------+
+| DAY |  SLOT   |      |
++-----+---------+------+
+| Mon | 9am     | Math |
+|     | 10am    | Art  |
+| Tue | Holiday |      |
++-----+---------+------+`,
+			SpanBlank,
 		},
 		{
-			"<ul><li>item</li></ul>_",
-			"* item\n\n_",
+			schedule,
+			`+-----+---------+---------+
+| DAY |  SLOT   |  SLOT   |
++-----+---------+---------+
+| Mon | 9am     | Math    |
+| Mon | 10am    | Art     |
+| Tue | Holiday | Holiday |
++-----+---------+---------+`,
+			SpanRepeat,
 		},
 		{
-			"<li class='123'>item 1</li> <li>item 2</li>\n_",
-			"* item 1\n* item 2\n_",
+			`<table>
+				<tr><td rowspan="3">a</td><td>b</td><td rowspan="2">c</td></tr>
+				<tr><td>d</td></tr>
+				<tr><td>e</td></tr>
+				<tr><td>f</td></tr>
+			</table>`,
+			`+---+---+---+
+| a | b | c |
+| a | d | c |
+| a | e |   |
+| f |   |   |
++---+---+---+`,
+			SpanRepeat,
 		},
 		{
-			"<li>item 1</li> \t\n <li>item 2</li> <li> item 3</li>\n_",
-			"* item 1\n* item 2\n* item 3\n_",
+			`<table>
+				<tbody>
+					<tr><td rowspan="0">a</td><td>b</td></tr>
+					<tr><td>c</td></tr>
+					<tr><td>d</td></tr>
+				</tbody>
+				<tbody><tr><td>e</td><td>f</td></tr></tbody>
+			</table>`,
+			`+---+---+
+| a | b |
+| a | c |
+| a | d |
+| e | f |
++---+---+`,
+			SpanRepeat,
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+		prettyOptions := NewPrettyTablesOptions()
+		prettyOptions.SpanStyle = testCase.spanStyle
+		options := Options{PrettyTables: true, PrettyTablesOptions: prettyOptions}
+		if msg, err := wantString(testCase.input, testCase.output, options); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
@@ -385,87 +510,63 @@ func TestStrippingLists(t *testing.T) {
 	}
 }
 
-func TestLinks(t *testing.T) {
+func TestNestedTables(t *testing.T) {
+	const order = `<table>
+		<tr><td>Order</td><td><table><tr><td>Item</td><td>Qty</td></tr><tr><td>Pen</td><td>2</td></tr></table></td></tr>
+		<tr><td>Total</td><td>2</td></tr>
+	</table>`
+
 	testCases := []struct {
-		input  string
-		output string
+		input        string
+		output       string
+		nestedTables NestedTableStyle
 	}{
 		{
-			`<a></a>`,
-			``,
-		},
-		{
-			`<a href=""></a>`,
-			``,
-		},
-		{
-			`<a href="http://example.com/"></a>`,
-			`( http://example.com/ )`,
-		},
-		{
-			`<a href="">Link</a>`,
-			`Link`,
-		},
-		{
-			`<a href="http://example.com/">Link</a>`,
-			`Link ( http://example.com/ )`,
-		},
-		{
-			`<a href="http://example.com/"><span class="a">Link</span></a>`,
-			`Link ( http://example.com/ )`,
-		},
-		{
-			"<a href='http://example.com/'>\n\t<span class='a'>Link</span>\n\t</a>",
-			`Link ( http://example.com/ )`,
-		},
-		{
-			"<a href='mailto:contact@example.org'>Contact Us</a>",
-			`Contact Us ( contact@example.org )`,
-		},
-		{
-			"<a href=\"http://example.com:80/~user?aaa=bb&amp;c=d,e,f#foo\">Link</a>",
-			`Link ( http://example.com:80/~user?aaa=bb&c=d,e,f#foo )`,
-		},
-		{
-			"<a title='title' href=\"http://example.com/\">Link</a>",
-			`Link ( http://example.com/ )`,
-		},
-		{
-			"<a href=\"   http://example.com/ \"> Link </a>",
-			`Link ( http://example.com/ )`,
-		},
-		{
-			"<a href=\"http://example.com/a/\">Link A</a> <a href=\"http://example.com/b/\">Link B</a>",
-			`Link A ( http://example.com/a/ ) Link B ( http://example.com/b/ )`,
-		},
-		{
-			"<a href=\"%%LINK%%\">Link</a>",
-			`Link ( %%LINK%% )`,
-		},
-		{
-			"<a href=\"[LINK]\">Link</a>",
-			`Link ( [LINK] )`,
-		},
-		{
-			"<a href=\"{LINK}\">Link</a>",
-			`Link ( {LINK} )`,
-		},
-		{
-			"<a href=\"[[!unsubscribe]]\">Link</a>",
-			`Link ( [[!unsubscribe]] )`,
+			order,
+			`+-------+----------------+
+| Order | +------+-----+ |
+|       | | Item | Qty | |
+|       | | Pen  |   2 | |
+|       | +------+-----+ |
+| Total |              2 |
++-------+----------------+`,
+			NestedInline,
 		},
 		{
-			"<p>This is <a href=\"http://www.google.com\" >link1</a> and <a href=\"http://www.google.com\" >link2 </a> is next.</p>",
-			`This is link1 ( http://www.google.com ) and link2 ( http://www.google.com ) is next.`,
+			order,
+			`+-------+---+
+| Order |   |
+| Total | 2 |
++-------+---+
+
++------+-----+
+| Item | Qty |
+| Pen  |   2 |
++------+-----+`,
+			NestedFlatten,
 		},
 		{
-			"<a href=\"http://www.google.com\" >http://www.google.com</a>",
-			`http://www.google.com`,
+			`<table><tr><td><table><tr><td><table><tr><td>deep</td></tr></table></td></tr></table></td><td>outer</td></tr></table>`,
+			`+--+-------+
+|  | outer |
++--+-------+
+
++--+
+|  |
++--+
+
++------+
+| deep |
++------+`,
+			NestedFlatten,
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+		prettyOptions := NewPrettyTablesOptions()
+		prettyOptions.NestedTables = testCase.nestedTables
+		options := Options{PrettyTables: true, PrettyTablesOptions: prettyOptions}
+		if msg, err := wantString(testCase.input, testCase.output, options); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
@@ -473,47 +574,65 @@ func TestLinks(t *testing.T) {
 	}
 }
 
-func TestOmitLinks(t *testing.T) {
+func TestLayoutTables(t *testing.T) {
 	testCases := []struct {
 		input  string
 		output string
 	}{
 		{
-			`<a></a>`,
-			``,
-		},
-		{
-			`<a href=""></a>`,
-			``,
-		},
-		{
-			`<a href="http://example.com/"></a>`,
-			``,
+			`<table role="presentation"><tr><td>Hello</td><td>World</td></tr></table>`,
+			"Hello\nWorld",
 		},
 		{
-			`<a href="">Link</a>`,
-			`Link`,
+			`<table><tr><td>Only</td></tr><tr><td>one column</td></tr></table>`,
+			"Only\none column",
 		},
 		{
-			`<a href="http://example.com/">Link</a>`,
-			`Link`,
+			`<table border="0" cellpadding="4"><tr><td>Left</td><td>Right</td></tr></table>`,
+			"Left\nRight",
 		},
 		{
-			`<a href="http://example.com/"><span class="a">Link</span></a>`,
-			`Link`,
+			`<table><tr><td>a</td><td>b</td></tr></table>`,
+			"+---+---+\n| a | b |\n+---+---+",
 		},
 		{
-			"<a href='http://example.com/'>\n\t<span class='a'>Link</span>\n\t</a>",
-			`Link`,
+			`<table border="0" cellpadding="4"><tr><th>Item</th><th>Price</th></tr><tr><td>Pen</td><td>$1</td></tr></table>`,
+			`+------+-------+
+| ITEM | PRICE |
++------+-------+
+| Pen  | $1    |
++------+-------+`,
 		},
 		{
-			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"></a>`,
-			`Example`,
+			`<table role="presentation" width="100%">
+				<tr><td><table border="0" cellpadding="0" cellspacing="0">
+					<tr><td><h1>Sale</h1></td></tr>
+					<tr><td>Everything must go.</td></tr>
+				</table></td></tr>
+				<tr><td><table>
+					<tr><th>Item</th><th>Price</th></tr>
+					<tr><td>Pen</td><td>$1</td></tr>
+				</table></td></tr>
+			</table>`,
+			`****
+Sale
+****
+
+Everything must go.
+
++------+-------+
+| ITEM | PRICE |
++------+-------+
+| Pen  | $1    |
++------+-------+`,
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output, Options{OmitLinks: true}); err != nil {
+		prettyOptions := NewPrettyTablesOptions()
+		prettyOptions.DetectLayoutTables = true
+		options := Options{PrettyTables: true, PrettyTablesOptions: prettyOptions}
+		if msg, err := wantString(testCase.input, testCase.output, options); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
@@ -521,48 +640,78 @@ func TestOmitLinks(t *testing.T) {
 	}
 }
 
-func TestImageAltTags(t *testing.T) {
+func TestTableFormats(t *testing.T) {
+	const prices = `<table>
+		<caption>Prices</caption>
+		<thead><tr><th>Item</th><th>Price</th></tr></thead>
+		<tbody>
+			<tr><td>Pen, blue</td><td>$1</td></tr>
+			<tr><td>Ink | black</td><td>$20</td></tr>
+		</tbody>
+		<tfoot><tr><td>Total</td><td>$21</td></tr></tfoot>
+	</table>`
+
 	testCases := []struct {
 		input  string
 		output string
+		format TableFormat
 	}{
 		{
-			`<img />`,
-			``,
-		},
-		{
-			`<img src="http://example.ru/hello.jpg" />`,
-			``,
-		},
-		{
-			`<img alt="Example"/>`,
-			``,
+			prices,
+			`Prices
+
+| Item | Price |
+| --- | --- |
+| Pen, blue | $1 |
+| Ink \| black | $20 |
+| Total | $21 |`,
+			TableMarkdown,
 		},
 		{
-			`<img src="http://example.ru/hello.jpg" alt="Example"/>`,
-			``,
+			`<table><tr><td>a</td><td>b</td></tr></table>`,
+			"|  |  |\n| --- | --- |\n| a | b |",
+			TableMarkdown,
 		},
-		// Images do matter if they are in a link.
 		{
-			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"/></a>`,
-			`Example ( http://example.com/ )`,
+			prices,
+			"Item,Price\n\"Pen, blue\",$1\nInk | black,$20\nTotal,$21",
+			TableCSV,
 		},
 		{
-			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"></a>`,
-			`Example ( http://example.com/ )`,
+			prices,
+			"Item\tPrice\nPen, blue\t$1\nInk | black\t$20\nTotal\t$21",
+			TableTSV,
 		},
 		{
-			`<a href='http://example.com/'><img src='http://example.ru/hello.jpg' alt='Example'/></a>`,
-			`Example ( http://example.com/ )`,
+			prices,
+			`Prices
+┌─────────────┬───────┐
+│ Item        │ Price │
+├─────────────┼───────┤
+│ Pen, blue   │ $1    │
+│ Ink | black │ $20   │
+├─────────────┼───────┤
+│ Total       │ $21   │
+└─────────────┴───────┘`,
+			TableUnicode,
 		},
 		{
-			`<a href='http://example.com/'><img src='http://example.ru/hello.jpg' alt='Example'></a>`,
-			`Example ( http://example.com/ )`,
+			prices,
+			`Prices
+Item: Pen, blue
+Price: $1
+
+Item: Ink | black
+Price: $20
+
+Item: Total
+Price: $21`,
+			TableRecords,
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+		if msg, err := wantString(testCase.input, testCase.output, Options{TableFormat: testCase.format}); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
@@ -570,108 +719,197 @@ func TestImageAltTags(t *testing.T) {
 	}
 }
 
-func TestHeadings(t *testing.T) {
+func TestDelimitedTables(t *testing.T) {
+	const order = `<table>
+		<caption>Order</caption>
+		<thead><tr><th>Item</th><th>Qty</th><th>Price</th></tr></thead>
+		<tbody>
+			<tr><td>Pen</td><td>2</td><td>$1.50</td></tr>
+			<tr><td>Notebook</td><td>10</td><td>$120.00</td></tr>
+		</tbody>
+		<tfoot><tr><td>Total</td><td>12</td><td>$121.50</td></tr></tfoot>
+	</table>`
+
 	testCases := []struct {
-		input  string
-		output string
+		input   string
+		output  string
+		options Options
 	}{
 		{
-			"<h1>Test</h1>",
-			"****\nTest\n****",
+			order,
+			"Order\nItem  Qty  Price\nPen  2  $1.50\nNotebook  10  $120.00\nTotal  12  $121.50",
+			Options{TableFormat: TableDelimited},
 		},
 		{
-			"\t<h1>\nTest</h1> ",
-			"****\nTest\n****",
+			order,
+			"Order\nItem\tQty\tPrice\nPen\t2\t$1.50\nNotebook\t10\t$120.00\nTotal\t12\t$121.50",
+			Options{TableFormat: TableDelimited, TableDelimiter: "\t"},
 		},
 		{
-			"\t<h1>\nTest line 1<br>Test 2</h1> ",
-			"***********\nTest line 1\nTest 2\n***********",
+			order,
+			`Order
+Item     | Qty |   Price
+Pen      |   2 |   $1.50
+Notebook |  10 | $120.00
+Total    |  12 | $121.50`,
+			Options{TableFormat: TableDelimited, TableDelimiter: " | ", TableAlignNumbers: true},
 		},
 		{
-			"<h1>Test</h1> <h1>Test</h1>",
-			"****\nTest\n****\n\n****\nTest\n****",
+			`<p>Before</p><table><tr><td>a b</td><td>-1,000</td><td>n/a</td></tr><tr><td>c</td><td>5%</td><td>7</td></tr></table><p>After</p>`,
+			"Before\n\na b  -1,000  n/a\nc        5%  7\n\nAfter",
+			Options{TableFormat: TableDelimited, TableAlignNumbers: true},
 		},
 		{
-			"<h2>Test</h2>",
-			"----\nTest\n----",
+			`<p>Before</p><table><tr><th>snake_case</th><th>n</th></tr><tr><td>a*b</td><td>1</td></tr></table><p>After</p>`,
+			"Before\n\n```\n┌────────────┬───┐\n│ snake_case │ n │\n├────────────┼───┤\n│ a*b        │ 1 │\n└────────────┴───┘\n```\n\nAfter",
+			Options{Format: FormatMarkdown, TableFormat: TableUnicode},
 		},
 		{
-			"<h1><a href='http://example.com/'>Test</a></h1>",
-			"****************************\nTest ( http://example.com/ )\n****************************",
+			`<table><tr><td>a</td><td>1</td></tr></table>`,
+			"```\n+---+---+\n| a | 1 |\n+---+---+\n```",
+			Options{Format: FormatMarkdown, PrettyTables: true},
 		},
 		{
-			"<h3> <span class='a'>Test </span></h3>",
-			"Test\n----",
+			`<table><tr><td>Widget</td><td>A very long description of the widget that does not fit in forty columns</td></tr></table>`,
+			"Widget,A very long description of the widget that does not fit in forty columns",
+			Options{TableFormat: TableCSV, LineWidth: 30},
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
 		}
 	}
-
 }
 
-func TestBold(t *testing.T) {
+func TestTableWidth(t *testing.T) {
+	const products = `<table>
+		<tr><th>Item</th><th>Description</th><th>Price</th></tr>
+		<tr><td>Golang</td><td>Open source programming language that makes it easy to build simple, reliable, and efficient software</td><td>$10.99</td></tr>
+		<tr><td>Hermes</td><td>Programmatically create beautiful e-mails using Golang.</td><td>$1.99</td></tr>
+	</table>`
+
+	prettyOptions := func(maxWidth int) *PrettyTablesOptions {
+		options := NewPrettyTablesOptions()
+		options.MaxWidth = maxWidth
+		return options
+	}
+
 	testCases := []struct {
-		input  string
-		output string
+		input   string
+		output  string
+		options Options
 	}{
 		{
-			"<b>Test</b>",
-			"*Test*",
-		},
-		{
-			"\t<b>Test</b> ",
-			"*Test*",
-		},
-		{
-			"\t<b>Test line 1<br>Test 2</b> ",
-			"*Test line 1\nTest 2*",
-		},
-		{
-			"<b>Test</b> <b>Test</b>",
-			"*Test* *Test*",
+			products,
+			`+--------+--------------------+--------+
+|  ITEM  |    DESCRIPTION     | PRICE  |
++--------+--------------------+--------+
+| Golang | Open source        | $10.99 |
+|        | programming        |        |
+|        | language that      |        |
+|        | makes it easy to   |        |
+|        | build simple,      |        |
+|        | reliable, and      |        |
+|        | efficient software |        |
+| Hermes | Programmatically   | $1.99  |
+|        | create beautiful   |        |
+|        | e-mails using      |        |
+|        | Golang.            |        |
++--------+--------------------+--------+`,
+			Options{PrettyTables: true, PrettyTablesOptions: prettyOptions(40)},
+		},
+		{
+			products,
+			`┌────────┬─────────────────────────────┬────────┐
+│ Item   │ Description                 │ Price  │
+├────────┼─────────────────────────────┼────────┤
+│ Golang │ Open source programming     │ $10.99 │
+│        │ language that makes it easy │        │
+│        │ to build simple, reliable,  │        │
+│        │ and efficient software      │        │
+│ Hermes │ Programmatically create     │ $1.99  │
+│        │ beautiful e-mails using     │        │
+│        │ Golang.                     │        │
+└────────┴─────────────────────────────┴────────┘`,
+			Options{TableFormat: TableUnicode, LineWidth: 50},
+		},
+		{
+			products,
+			`Item: Golang
+Description: Open
+source programming
+language that makes
+it easy to build
+simple, reliable,
+and efficient
+software
+Price: $10.99
+
+Item: Hermes
+Description:
+Programmatically
+create beautiful
+e-mails using
+Golang.
+Price: $1.99`,
+			Options{PrettyTables: true, PrettyTablesOptions: prettyOptions(20)},
+		},
+		{
+			`<table><tr><td>short</td><td>table</td></tr></table>`,
+			"+-------+-------+\n| short | table |\n+-------+-------+",
+			Options{PrettyTables: true, PrettyTablesOptions: prettyOptions(20)},
+		},
+		{
+			`<table><tr><td>Widget</td><td>A very long description of the widget that does not fit in forty columns</td></tr></table>`,
+			`+--------+----------------------------+
+| Widget | A very long description of |
+|        | the widget that does not   |
+|        | fit in forty columns       |
++--------+----------------------------+`,
+			Options{PrettyTables: true, LineWidth: 40},
+		},
+		{
+			`<table><tr><td>Widget</td><td>A very long description of the widget that does not fit in forty columns</td></tr></table>`,
+			`+--------+--------------------------------------------------------------------------+
+| Widget | A very long description of the widget that does not fit in forty columns |
++--------+--------------------------------------------------------------------------+`,
+			Options{PrettyTables: true, PrettyTablesOptions: prettyOptions(100), LineWidth: 40},
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
 		}
 	}
-
 }
 
-func TestDiv(t *testing.T) {
+func TestStrippingLists(t *testing.T) {
 	testCases := []struct {
 		input  string
 		output string
 	}{
 		{
-			"<div>Test</div>",
-			"Test",
-		},
-		{
-			"\t<div>Test</div> ",
-			"Test",
+			"<ul></ul>",
+			"",
 		},
 		{
-			"<div>Test line 1<div>Test 2</div></div>",
-			"Test line 1\nTest 2",
+			"<ul><li>item</li></ul>_",
+			"* item\n\n_",
 		},
 		{
-			"Test 1<div>Test 2</div> <div>Test 3</div>Test 4",
-			"Test 1\nTest 2\nTest 3\nTest 4",
+			"<li class='123'>item 1</li> <li>item 2</li>\n_",
+			"* item 1\n* item 2\n_",
 		},
 		{
-			"Test 1<div>&nbsp;Test 2&nbsp;</div>",
-			"Test 1\nTest 2",
+			"<li>item 1</li> \t\n <li>item 2</li> <li> item 3</li>\n_",
+			"* item 1\n* item 2\n* item 3\n_",
 		},
 	}
 
@@ -682,43 +920,1178 @@ func TestDiv(t *testing.T) {
 			t.Log(msg)
 		}
 	}
-
 }
 
-func TestBlockquotes(t *testing.T) {
+func TestOrderedLists(t *testing.T) {
 	testCases := []struct {
 		input  string
 		output string
 	}{
 		{
-			"<div>level 0<blockquote>level 1<br><blockquote>level 2</blockquote>level 1</blockquote><div>level 0</div></div>",
-			"level 0\n> \n> level 1\n> \n>> level 2\n> \n> level 1\n\nlevel 0",
+			"<ol></ol>",
+			"",
 		},
 		{
-			"<blockquote>Test</blockquote>Test",
-			"> \n> Test\n\nTest",
+			"_<ol><li>item 1</li><li>item 2</li></ol>_",
+			"_\n\n1. item 1\n2. item 2\n\n_",
 		},
 		{
-			"\t<blockquote> \nTest<br></blockquote> ",
-			"> \n> Test\n>",
+			`<ol start="9"><li>item 9</li><li>item 10</li></ol>`,
+			" 9. item 9\n10. item 10",
 		},
 		{
-			"\t<blockquote> \nTest line 1<br>Test 2</blockquote> ",
-			"> \n> Test line 1\n> Test 2",
+			`<ol reversed><li>item 3</li><li>item 2</li><li>item 1</li></ol>`,
+			"3. item 3\n2. item 2\n1. item 1",
 		},
 		{
-			"<blockquote>Test</blockquote> <blockquote>Test</blockquote> Other Test",
-			"> \n> Test\n\n> \n> Test\n\nOther Test",
+			`<ol reversed start="10"><li>item 10</li><li>item 9</li></ol>`,
+			"10. item 10\n 9. item 9",
 		},
 		{
-			"<blockquote>Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua ad sit consequat quis ex commodo Duis incididunt eu mollit consectetur fugiat voluptate dolore in pariatur in commodo occaecat Ut occaecat velit esse labore aute quis commodo non sit dolore officia Excepteur cillum amet cupidatat culpa velit labore ullamco dolore mollit elit in aliqua dolor irure do</blockquote>",
-			"> \n> Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua ad\n> sit consequat quis ex commodo Duis incididunt eu mollit consectetur fugiat\n> voluptate dolore in pariatur in commodo occaecat Ut occaecat velit esse\n> labore aute quis commodo non sit dolore officia Excepteur cillum amet\n> cupidatat culpa velit labore ullamco dolore mollit elit in aliqua dolor\n> irure do",
+			`<ol><li>item 1</li><li value="5">item 5</li><li>item 6</li></ol>`,
+			"1. item 1\n5. item 5\n6. item 6",
 		},
 		{
-			"<blockquote>Lorem<b>ipsum</b><b>Commodo</b><b>id</b><b>consectetur</b><b>pariatur</b><b>ea</b><b>occaecat</b><b>minim</b><b>aliqua</b><b>ad</b><b>sit</b><b>consequat</b><b>quis</b><b>ex</b><b>commodo</b><b>Duis</b><b>incididunt</b><b>eu</b><b>mollit</b><b>consectetur</b><b>fugiat</b><b>voluptate</b><b>dolore</b><b>in</b><b>pariatur</b><b>in</b><b>commodo</b><b>occaecat</b><b>Ut</b><b>occaecat</b><b>velit</b><b>esse</b><b>labore</b><b>aute</b><b>quis</b><b>commodo</b><b>non</b><b>sit</b><b>dolore</b><b>officia</b><b>Excepteur</b><b>cillum</b><b>amet</b><b>cupidatat</b><b>culpa</b><b>velit</b><b>labore</b><b>ullamco</b><b>dolore</b><b>mollit</b><b>elit</b><b>in</b><b>aliqua</b><b>dolor</b><b>irure</b><b>do</b></blockquote>",
-			"> \n> Lorem *ipsum* *Commodo* *id* *consectetur* *pariatur* *ea* *occaecat* *minim*\n> *aliqua* *ad* *sit* *consequat* *quis* *ex* *commodo* *Duis* *incididunt* *eu*\n> *mollit* *consectetur* *fugiat* *voluptate* *dolore* *in* *pariatur* *in* *commodo*\n> *occaecat* *Ut* *occaecat* *velit* *esse* *labore* *aute* *quis* *commodo*\n> *non* *sit* *dolore* *officia* *Excepteur* *cillum* *amet* *cupidatat* *culpa*\n> *velit* *labore* *ullamco* *dolore* *mollit* *elit* *in* *aliqua* *dolor* *irure*\n> *do*",
+			`<ol type="a" start="26"><li>item z</li><li>item aa</li></ol>`,
+			" z. item z\naa. item aa",
 		},
-	}
+		{
+			`<ol type="A"><li>item A</li><li>item B</li></ol>`,
+			"A. item A\nB. item B",
+		},
+		{
+			`<ol type="i" start="3"><li>item iii</li><li>item iv</li></ol>`,
+			"iii. item iii\n iv. item iv",
+		},
+		{
+			`<ol type="I" start="1994"><li>item MCMXCIV</li></ol>`,
+			"MCMXCIV. item MCMXCIV",
+		},
+		{
+			`<ol type="i" start="0"><li>item 0</li></ol>`,
+			"0. item 0",
+		},
+		{
+			`<ul><li>item</li></ul><ol><li>item 1</li></ol>`,
+			"* item\n\n1. item 1",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestNestedLists(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			"<ul><li>item 1<ul><li>item 1.1<ul><li>item 1.1.1</li></ul></li><li>item 1.2</li></ul></li><li>item 2</li></ul>",
+			"* item 1\n  * item 1.1\n    * item 1.1.1\n  * item 1.2\n* item 2",
+			Options{},
+		},
+		{
+			"<ol><li>item 1<br>continued<ol><li>item 1.1</li></ol></li><li>item 2</li></ol>",
+			"1. item 1\n   continued\n   1. item 1.1\n2. item 2",
+			Options{},
+		},
+		{
+			"<ul><li><p>para</p></li><li><div>a</div><div>b</div></li><li><h3>Title</h3>text</li></ul>",
+			"* para\n\n* a\n  b\n* Title\n  -----\n\n  text",
+			Options{},
+		},
+		{
+			"<ul><li><table><tr><td>a</td><td>b</td></tr></table></li></ul>",
+			"* +---+---+\n  | a | b |\n  +---+---+",
+			Options{PrettyTables: true},
+		},
+		{
+			"<ul><li>item 1<ul><li>item 1.1<ul><li>item 1.1.1</li></ul></li></ul></li></ul>",
+			"+ item 1\n    - item 1.1\n        + item 1.1.1",
+			Options{ListIndent: 4, ListBullets: []string{"+", "-"}},
+		},
+		{
+			"<ol><li>item 1<ul><li>item 1.1</li></ul></li></ol>",
+			"1. item 1\n   - item 1.1",
+			Options{Format: FormatMarkdown},
+		},
+		{
+			"<ul><li>item 1<ul><li>item 1.1</li></ul></li></ul>",
+			"item 1\nitem 1.1",
+			Options{TextOnly: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestDefinitionLists(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			"<p>Test</p><dl><dt>Term 1</dt><dd>Definition 1</dd><dt>Term 2</dt><dd>Definition 2<br>continued</dd></dl><p>Test</p>",
+			"Test\n\nTerm 1\n    Definition 1\nTerm 2\n    Definition 2\n    continued\n\nTest",
+			Options{},
+		},
+		{
+			"<dl><dt>Term</dt><dd>Definition 1</dd><dd>Definition 2</dd></dl>",
+			"Term\n    Definition 1\n    Definition 2",
+			Options{},
+		},
+		{
+			"<dl><dt>Subtotal</dt><dd>$12.00</dd><dt>Shipping</dt><dd>Delivered within a few business days</dd></dl>",
+			"Subtotal: $12.00\nShipping\n    Delivered within a few business days",
+			Options{CompactDefinitions: 30},
+		},
+		{
+			"<dl><dt>Term</dt><dd>Definition 1</dd><dd>Definition 2</dd></dl>",
+			"Term\n    Definition 1\n    Definition 2",
+			Options{CompactDefinitions: 30},
+		},
+		{
+			"<dl><dt>Term</dt><dd>Definition</dd></dl>",
+			"Term\n: Definition",
+			Options{Format: FormatMarkdown},
+		},
+		{
+			"<dl><dt>Term</dt><dd><p>a</p><p>b</p></dd></dl>",
+			"Term\n: a\n\n  b",
+			Options{Format: FormatMarkdown},
+		},
+		{
+			"<dl><dt>Term</dt><dd>Definition</dd></dl>",
+			"Term\nDefinition",
+			Options{TextOnly: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<a></a>`,
+			``,
+		},
+		{
+			`<a href=""></a>`,
+			``,
+		},
+		{
+			`<a href="http://example.com/"></a>`,
+			`( http://example.com/ )`,
+		},
+		{
+			`<a href="">Link</a>`,
+			`Link`,
+		},
+		{
+			`<a href="http://example.com/">Link</a>`,
+			`Link ( http://example.com/ )`,
+		},
+		{
+			`<a href="http://example.com/"><span class="a">Link</span></a>`,
+			`Link ( http://example.com/ )`,
+		},
+		{
+			"<a href='http://example.com/'>\n\t<span class='a'>Link</span>\n\t</a>",
+			`Link ( http://example.com/ )`,
+		},
+		{
+			"<a href='mailto:contact@example.org'>Contact Us</a>",
+			`Contact Us ( contact@example.org )`,
+		},
+		{
+			"<a href=\"http://example.com:80/~user?aaa=bb&amp;c=d,e,f#foo\">Link</a>",
+			`Link ( http://example.com:80/~user?aaa=bb&c=d,e,f#foo )`,
+		},
+		{
+			"<a title='title' href=\"http://example.com/\">Link</a>",
+			`Link ( http://example.com/ )`,
+		},
+		{
+			"<a href=\"   http://example.com/ \"> Link </a>",
+			`Link ( http://example.com/ )`,
+		},
+		{
+			"<a href=\"http://example.com/a/\">Link A</a> <a href=\"http://example.com/b/\">Link B</a>",
+			`Link A ( http://example.com/a/ ) Link B ( http://example.com/b/ )`,
+		},
+		{
+			"<a href=\"%%LINK%%\">Link</a>",
+			`Link ( %%LINK%% )`,
+		},
+		{
+			"<a href=\"[LINK]\">Link</a>",
+			`Link ( [LINK] )`,
+		},
+		{
+			"<a href=\"{LINK}\">Link</a>",
+			`Link ( {LINK} )`,
+		},
+		{
+			"<a href=\"[[!unsubscribe]]\">Link</a>",
+			`Link ( [[!unsubscribe]] )`,
+		},
+		{
+			"<p>This is <a href=\"http://www.google.com\" >link1</a> and <a href=\"http://www.google.com\" >link2 </a> is next.</p>",
+			`This is link1 ( http://www.google.com ) and link2 ( http://www.google.com ) is next.`,
+		},
+		{
+			"<a href=\"http://www.google.com\" >http://www.google.com</a>",
+			`http://www.google.com`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestLinkReferences(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<a href="http://example.com/">Link</a>`,
+			"Link [1]\n\nReferences:\n[1] http://example.com/",
+			Options{LinkStyle: LinkReferences},
+		},
+		{
+			`<p><a href="http://example.com/a/">Link A</a> <a href="http://example.com/b/">Link B</a> <a href="http://example.com/a/">Link A again</a></p>`,
+			"Link A [1] Link B [2] Link A again [1]\n\nReferences:\n[1] http://example.com/a/\n[2] http://example.com/b/",
+			Options{LinkStyle: LinkReferences},
+		},
+		{
+			`<a href="http://example.com/">http://example.com/</a>`,
+			"http://example.com/",
+			Options{LinkStyle: LinkReferences},
+		},
+		{
+			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"></a>`,
+			"Example [1]\n\nReferences:\n[1] http://example.com/",
+			Options{LinkStyle: LinkReferences},
+		},
+		{
+			`<table><tr><td><a href="http://example.com/">Link</a></td></tr></table>`,
+			"+----------+\n| Link [1] |\n+----------+\n\nReferences:\n[1] http://example.com/",
+			Options{LinkStyle: LinkReferences, PrettyTables: true},
+		},
+		{
+			`<a href="http://example.com/">Link</a>`,
+			"Link",
+			Options{LinkStyle: LinkReferences, OmitLinks: true},
+		},
+		{
+			`<a href="http://example.com/">Link</a>`,
+			"Link",
+			Options{LinkStyle: LinkReferences, TextOnly: true},
+		},
+		{
+			`<a href="http://example.com/">Link</a> <em><a href="http://example.com/">Again</a></em>`,
+			"[Link][1] _[Again][1]_\n\n[1]: http://example.com/",
+			Options{LinkStyle: LinkReferences, Format: FormatMarkdown},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		baseURL string
+	}{
+		{
+			`<a href="/account/settings">Settings</a>`,
+			`Settings ( https://example.com/account/settings )`,
+			"https://example.com/docs/index.html",
+		},
+		{
+			`<a href="../img.png">Image</a>`,
+			`Image ( https://example.com/img.png )`,
+			"https://example.com/docs/index.html",
+		},
+		{
+			`<a href="http://example.org/">Absolute</a>`,
+			`Absolute ( http://example.org/ )`,
+			"https://example.com/docs/index.html",
+		},
+		{
+			`<a href="mailto:contact@example.org">Contact Us</a>`,
+			`Contact Us ( contact@example.org )`,
+			"https://example.com/docs/index.html",
+		},
+		{
+			`<a href="%%LINK%%">Link</a>`,
+			`Link ( %%LINK%% )`,
+			"https://example.com/docs/index.html",
+		},
+		{
+			`<html><head><base href="https://example.net/base/"></head><body><a href="page">Page</a></body></html>`,
+			`Page ( https://example.net/base/page )`,
+			"",
+		},
+		{
+			`<html><head><base href="/base/"></head><body><a href="page">Page</a></body></html>`,
+			`Page ( https://example.com/base/page )`,
+			"https://example.com/docs/index.html",
+		},
+		{
+			`<html><head><base href="/base/"></head><body><a href="page">Page</a></body></html>`,
+			`Page ( page )`,
+			"",
+		},
+		{
+			`<a href="/page">Page</a>`,
+			`Page ( /page )`,
+			"%%BASE%%",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{BaseURL: testCase.baseURL}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	if msg, err := wantString(`<a href="/page">Page</a>`, `[Page](https://example.com/page)`, Options{BaseURL: "https://example.com/", Format: FormatMarkdown}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+}
+
+func TestOmitLinks(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<a></a>`,
+			``,
+		},
+		{
+			`<a href=""></a>`,
+			``,
+		},
+		{
+			`<a href="http://example.com/"></a>`,
+			``,
+		},
+		{
+			`<a href="">Link</a>`,
+			`Link`,
+		},
+		{
+			`<a href="http://example.com/">Link</a>`,
+			`Link`,
+		},
+		{
+			`<a href="http://example.com/"><span class="a">Link</span></a>`,
+			`Link`,
+		},
+		{
+			"<a href='http://example.com/'>\n\t<span class='a'>Link</span>\n\t</a>",
+			`Link`,
+		},
+		{
+			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"></a>`,
+			`Example`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{OmitLinks: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestImageAltTags(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<img />`,
+			``,
+		},
+		{
+			`<img src="http://example.ru/hello.jpg" />`,
+			``,
+		},
+		{
+			`<img alt="Example"/>`,
+			``,
+		},
+		{
+			`<img src="http://example.ru/hello.jpg" alt="Example"/>`,
+			``,
+		},
+		// Images do matter if they are in a link.
+		{
+			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"/></a>`,
+			`Example ( http://example.com/ )`,
+		},
+		{
+			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"></a>`,
+			`Example ( http://example.com/ )`,
+		},
+		{
+			`<a href='http://example.com/'><img src='http://example.ru/hello.jpg' alt='Example'/></a>`,
+			`Example ( http://example.com/ )`,
+		},
+		{
+			`<a href='http://example.com/'><img src='http://example.ru/hello.jpg' alt='Example'></a>`,
+			`Example ( http://example.com/ )`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestImageStyles(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<p>Buy <img src="/shoe.png" alt="Running Shoe"> now</p>`,
+			"Buy now",
+			Options{},
+		},
+		{
+			`<p>Buy <img src="/shoe.png" alt="Running Shoe"> now</p>`,
+			"Buy Running Shoe now",
+			Options{ImageStyle: ImageAltText},
+		},
+		{
+			`<p>Buy <img src="/shoe.png" alt="Running Shoe"> now <img src="/spacer.gif" alt=""><img src="/pixel.gif" alt="Pixel" role="presentation"></p>`,
+			"Buy [image: Running Shoe] now",
+			Options{ImageStyle: ImageLabeled},
+		},
+		{
+			`<img src="/shoe.png">`,
+			"[image]",
+			Options{ImageStyle: ImageLabeled},
+		},
+		{
+			`<img src="/shoe.png" alt="Running Shoe">`,
+			"[image: Running Shoe] ( http://example.com/shoe.png )",
+			Options{ImageStyle: ImageLabeled, ImageSources: true, BaseURL: "http://example.com/"},
+		},
+		{
+			`<img src="http://example.com/shoe.png" alt="Running Shoe">`,
+			"Running Shoe [1]\n\nReferences:\n[1] http://example.com/shoe.png",
+			Options{ImageStyle: ImageAltText, ImageSources: true, LinkStyle: LinkReferences},
+		},
+		{
+			`<img src="http://example.com/shoe.png" alt="Running Shoe">`,
+			"Running Shoe",
+			Options{ImageStyle: ImageLabeled, ImageSources: true, TextOnly: true},
+		},
+		{
+			`<img src="http://example.com/shoe.png" alt="Running Shoe">`,
+			"![Running Shoe](http://example.com/shoe.png)",
+			Options{ImageStyle: ImageLabeled, ImageSources: true, Format: FormatMarkdown},
+		},
+		{
+			`<img src="http://example.com/shoe.png" alt="Running Shoe">`,
+			"\\[image: Running Shoe\\]",
+			Options{ImageStyle: ImageLabeled, Format: FormatMarkdown},
+		},
+		{
+			`<img src="http://example.com/shoe.png" alt="Running Shoe">`,
+			"Running Shoe",
+			Options{ImageStyle: ImageAltText, ImageSources: true, OmitLinks: true, Format: FormatMarkdown},
+		},
+		{
+			`<a href="http://example.com/"><img src="http://example.com/shoe.png" alt="Running Shoe"></a>`,
+			"Running Shoe ( http://example.com/ )",
+			Options{ImageStyle: ImageLabeled},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestHiddenContent(t *testing.T) {
+	const email = `<div style="display:none; max-height:0; overflow:hidden">Preheader: 50% off today</div>
+		<p>Dear customer,</p>
+		<p>Thanks for your order<span aria-hidden="true"> &#x2714;</span>.</p>
+		<p hidden>Track me</p>
+		<p style="font-size: 0px">buy cheap pills</p>
+		<div style="MAX-HEIGHT: 0; OVERFLOW: HIDDEN !important">Collapsed</div>
+		<div style="max-height: 0">Overflowing</div>
+		<p style="visibility:hidden">Invisible</p>`
+
+	testCases := []struct {
+		input  string
+		output string
+		mode   HiddenContentMode
+	}{
+		{
+			email,
+			"Preheader: 50% off today\n\nDear customer,\n\nThanks for your order ✔.\n\nTrack me\n\nbuy cheap pills\n\nCollapsed\nOverflowing\n\nInvisible",
+			HiddenRender,
+		},
+		{
+			email,
+			"Dear customer,\n\nThanks for your order.\n\nOverflowing",
+			HiddenSkip,
+		},
+		{
+			email,
+			"Preheader: 50% off today\n\n✔\n\nTrack me\n\nbuy cheap pills\n\nCollapsed\n\nInvisible",
+			HiddenOnly,
+		},
+		{
+			`<p>Visible <a href="http://example.com/">link</a></p><div hidden><a href="http://spam.example.com/">hidden link</a></div>`,
+			"hidden link ( http://spam.example.com/ )",
+			HiddenOnly,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{HiddenContent: testCase.mode}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestEmbeddedStyles(t *testing.T) {
+	const stylesheet = `<style>
+		/* Email client hacks */ <!--
+		.preheader { display: none !important; }
+		@media (max-width: 600px) { .desktop { display: none } }
+		span.line { display: block }
+		#title { text-transform: uppercase }
+		.shout b { text-transform: capitalize }
+		td > p { display: none }
+		.code { white-space: pre }
+		div.inline, p.inline { display: inline }
+		-->
+	</style>`
+
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			stylesheet + `<div class="preheader">Secret preview</div><div class="desktop">Desktop</div>`,
+			"Secret preview\nDesktop",
+			Options{},
+		},
+		{
+			stylesheet + `<div class="preheader">Secret preview</div><div class="desktop">Desktop</div>`,
+			"Desktop",
+			Options{EmbeddedStyles: true},
+		},
+		{
+			stylesheet + `<h1 id="title">Hello there</h1><p class="shout">Say <b>big news now</b></p>`,
+			"***********\nHELLO THERE\n***********\n\nSay *Big News Now*",
+			Options{EmbeddedStyles: true},
+		},
+		{
+			stylesheet + `<p><span class="line">Line one</span><span class="line">Line two</span> tail</p>`,
+			"Line one\nLine two\ntail",
+			Options{EmbeddedStyles: true},
+		},
+		{
+			stylesheet + "<div class=\"code\">a   b\n  c</div><div class=\"inline\">one</div> <div class=\"inline\">two</div>",
+			"a   b\n  c\none two",
+			Options{EmbeddedStyles: true},
+		},
+		{
+			`<style>section{display:inline}</style>a <section>b</section> c`,
+			"a b c",
+			Options{EmbeddedStyles: true},
+		},
+		{
+			stylesheet + `<table><tr><td><p>Unsupported selector</p></td></tr></table>`,
+			"Unsupported selector",
+			Options{EmbeddedStyles: true},
+		},
+		{
+			stylesheet + `<p>Visible</p><div class="preheader">Secret preview</div><p style="display:none">Inline hidden</p>`,
+			"Secret preview\n\nInline hidden",
+			Options{EmbeddedStyles: true, HiddenContent: HiddenOnly},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestInlineSpacing(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"foo<b>bar</b>",
+			"foo*bar*",
+		},
+		{
+			"foo <b>bar</b> baz",
+			"foo *bar* baz",
+		},
+		{
+			"foo <b> bar </b>baz",
+			"foo *bar* baz",
+		},
+		{
+			"<span>$</span><span>10</span>",
+			"$10",
+		},
+		{
+			"word<sup>2</sup> and <span>more</span>\n\t<span>words</span>",
+			"word2 and more words",
+		},
+		{
+			`<a href="http://example.com/">link </a>next`,
+			"link ( http://example.com/ ) next",
+		},
+		{
+			"<p>Run <code>make</code>, then <kbd>q</kbd>.</p>",
+			"Run `make`, then `q`.",
+		},
+		{
+			"<table><tr><td>cell1</td><td>cell2</td></tr><tr><td>cell3</td></tr></table>",
+			"cell1 cell2 cell3",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestBlockElements(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<header>Logo</header><nav>Home | About</nav><main><article><section>One</section><section>Two</section></article></main><footer>Bye</footer>",
+			"Logo\nHome | About\nOne\nTwo\nBye",
+		},
+		{
+			"<p>Intro</p><section><h2>Title</h2><p>Body</p></section><aside>Note</aside>text after",
+			"Intro\n\n-----\nTitle\n-----\n\nBody\n\nNote\ntext after",
+		},
+		{
+			"before<figure><img alt=\"Chart\"><figcaption>Sales</figcaption></figure>after",
+			"before\nSales\nafter",
+		},
+		{
+			"<x-widget>custom</x-widget><x-widget>elements</x-widget> are inline",
+			"customelements are inline",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestHeadings(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<h1>Test</h1>",
+			"****\nTest\n****",
+		},
+		{
+			"\t<h1>\nTest</h1> ",
+			"****\nTest\n****",
+		},
+		{
+			"\t<h1>\nTest line 1<br>Test 2</h1> ",
+			"***********\nTest line 1\nTest 2\n***********",
+		},
+		{
+			"<h1>Test</h1> <h1>Test</h1>",
+			"****\nTest\n****\n\n****\nTest\n****",
+		},
+		{
+			"<h2>Test</h2>",
+			"----\nTest\n----",
+		},
+		{
+			"<h1><a href='http://example.com/'>Test</a></h1>",
+			"****************************\nTest ( http://example.com/ )\n****************************",
+		},
+		{
+			"<h3> <span class='a'>Test </span></h3>",
+			"Test\n----",
+		},
+		{
+			"<p>Test</p><h4>Test</h4><p>Test</p>",
+			"Test\n\nTest\n....\n\nTest",
+		},
+		{
+			"<p>Test</p><h5>Test</h5>Test<h6>Test</h6>",
+			"Test\n\nTest\n\nTest\n\nTest",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+}
+
+func TestHeadingStyles(t *testing.T) {
+	styles := []HeadingStyle{
+		{Underline: "="},
+		{Prefix: "## "},
+		{Prefix: "### "},
+		{Uppercase: true, Underline: "~"},
+	}
+
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			"<h1>Test</h1><h2>Test</h2><h3>Test line 1<br>Test 2</h3>",
+			"Test\n====\n\n## Test\n\n### Test line 1\nTest 2",
+			Options{HeadingStyles: styles},
+		},
+		{
+			"<h4>Test</h4><h5>Test</h5>",
+			"TEST\n~~~~\n\nTest",
+			Options{HeadingStyles: styles},
+		},
+		{
+			"<h4>See <a href='http://example.com/Path'>the docs</a></h4>",
+			"SEE THE DOCS ( http://example.com/Path )\n~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~",
+			Options{HeadingStyles: styles},
+		},
+		{
+			"<h4>Test</h4><h5>Test</h5><h6>Test</h6>",
+			"Test.\n\nTest.\n\nTest.",
+			Options{TextOnly: true},
+		},
+		{
+			"<h2>Lorem ipsum dolor sit amet</h2>",
+			"## Lorem ipsum\ndolor sit amet",
+			Options{HeadingStyles: styles, LineWidth: 16},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestBold(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<b>Test</b>",
+			"*Test*",
+		},
+		{
+			"\t<b>Test</b> ",
+			"*Test*",
+		},
+		{
+			"\t<b>Test line 1<br>Test 2</b> ",
+			"*Test line 1\nTest 2*",
+		},
+		{
+			"<b>Test</b> <b>Test</b>",
+			"*Test* *Test*",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+}
+
+func TestInlineMarkers(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			"<i>Test</i> <em>Test</em> <u>Test</u> <s>Test</s> <strike>Test</strike> <mark>Test</mark>",
+			"/Test/ /Test/ _Test_ ~Test~ ~Test~ ==Test==",
+			Options{},
+		},
+		{
+			"was <del>$40</del> <ins>now $20</ins>",
+			"was [-$40-] {+now $20+}",
+			Options{},
+		},
+		{
+			"<em>Test <b>bold</b></em><em></em>",
+			"/Test *bold*/",
+			Options{},
+		},
+		{
+			"<em>Test</em> <del>Test</del> <u>Test</u>",
+			"Test [-Test-] Test",
+			Options{InlineMarkers: &InlineMarkers{Deleted: &InlineMarker{"[-", "-]"}}},
+		},
+		{
+			"<em>Test</em> <del>Test</del> <u>Test</u>",
+			"Test Test Test",
+			Options{TextOnly: true},
+		},
+		{
+			"<em>Test</em> <del>Test</del> <u>Test</u>",
+			"_Test_ ~~Test~~ Test",
+			Options{Format: FormatMarkdown},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestCode(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			"<p>Run <code>go test</code> or <kbd>make</kbd>, see <samp>ok</samp> for <var>pkg</var>.</p>",
+			"Run `go test` or `make`, see `ok` for `pkg`.",
+			Options{},
+		},
+		{
+			"Run <code>go test</code>",
+			"Run 'go test'",
+			Options{CodeMarker: "'"},
+		},
+		{
+			"Run <code>go test</code>",
+			"Run go test",
+			Options{TextOnly: true},
+		},
+		{
+			"Quote <code>a`b</code>",
+			"Quote `` a`b ``",
+			Options{Format: FormatMarkdown},
+		},
+		{
+			"<p>Test</p><pre><code>func main() {\n    x := 1\n\n\n\n}</code></pre><p>Test</p>",
+			"Test\n\nfunc main() {\n    x := 1\n\n\n\n}\n\nTest",
+			Options{},
+		},
+		{
+			"<p>Test</p><pre>a <span>b</span>c</pre><p>Test</p>",
+			"Test\n\na bc\n\nTest",
+			Options{},
+		},
+		{
+			"<p>Test</p><pre><code>func main() {\n    x := 1\n}</code></pre>",
+			"Test\n\n    func main() {\n        x := 1\n    }",
+			Options{CodeBlockStyle: CodeBlockIndented},
+		},
+		{
+			"<pre><code class=\"hljs language-go\">func main() {\n    x := 1\n}\n</code></pre>",
+			"```go\nfunc main() {\n    x := 1\n}\n```",
+			Options{CodeBlockStyle: CodeBlockFenced},
+		},
+		{
+			"<pre class=\"lang-sh\">  ls\n  pwd</pre>",
+			"```sh\n  ls\n  pwd\n```",
+			Options{Format: FormatMarkdown},
+		},
+		{
+			"<pre>```\ncode\n```</pre>",
+			"````\n```\ncode\n```\n````",
+			Options{Format: FormatMarkdown},
+		},
+		{
+			"<pre>x = <b>1</b> <a href=\"http://x/\">y</a> <em>z</em></pre>",
+			"```\nx = 1 y z\n```",
+			Options{Format: FormatMarkdown},
+		},
+		{
+			"<blockquote><pre>Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt</pre></blockquote>",
+			"> \n> \n> \n> Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt\n> \n>",
+			Options{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestSubRenderingOptions(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			"<h1><a href='http://example.com/'>Test</a></h1>",
+			"****\nTest\n****",
+			Options{OmitLinks: true},
+		},
+		{
+			"<h1><a href='http://example.com/'>Test</a></h1>",
+			"Test.",
+			Options{TextOnly: true},
+		},
+		{
+			"<h2><a href='/page'>Test</a></h2>",
+			"--------------------------------\nTest ( http://example.com/page )\n--------------------------------",
+			Options{BaseURL: "http://example.com/"},
+		},
+		{
+			"<h1><a href='http://example.com/'>Test</a></h1><p><b><a href='http://example.com/'>Bold</a></b></p>",
+			"********\nTest [1]\n********\n\n*Bold [1]*\n\nReferences:\n[1] http://example.com/",
+			Options{LinkStyle: LinkReferences},
+		},
+		{
+			"<h1>Test <b>line</b> 1<br><a href='http://example.com/'>Test</a> 2</h1>",
+			"******************************\nTest *line* 1\nTest ( http://example.com/ ) 2\n******************************",
+			Options{},
+		},
+		{
+			"<b><a href='http://example.com/'>Test</a></b>",
+			"**[Test](http://example.com/)**",
+			Options{Format: FormatMarkdown},
+		},
+		{
+			"<pre><b>Test  line</b></pre>",
+			"*Test  line*",
+			Options{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestDiv(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<div>Test</div>",
+			"Test",
+		},
+		{
+			"\t<div>Test</div> ",
+			"Test",
+		},
+		{
+			"<div>Test line 1<div>Test 2</div></div>",
+			"Test line 1\nTest 2",
+		},
+		{
+			"Test 1<div>Test 2</div> <div>Test 3</div>Test 4",
+			"Test 1\nTest 2\nTest 3\nTest 4",
+		},
+		{
+			"Test 1<div>&nbsp;Test 2&nbsp;</div>",
+			"Test 1\nTest 2",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+}
+
+func TestHorizontalRules(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			"Test<hr>Test",
+			"Test\n\n" + strings.Repeat("-", 74) + "\n\nTest",
+			Options{},
+		},
+		{
+			"<p>Test</p><hr/><p>Test</p>",
+			"Test\n\n====================\n\nTest",
+			Options{HorizontalRule: "=", LineWidth: 20},
+		},
+		{
+			"<blockquote>Test<hr>Test</blockquote>",
+			"> \n> Test\n> \n> ~-~-~-~-~-\n> \n> Test",
+			Options{HorizontalRule: "~-", LineWidth: 12},
+		},
+		{
+			"Test<hr>Test",
+			"Test\n\n---\n\nTest",
+			Options{Format: FormatMarkdown},
+		},
+		{
+			"Test<hr>Test",
+			"Test\n\nTest",
+			Options{TextOnly: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestBlockquotes(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<div>level 0<blockquote>level 1<br><blockquote>level 2</blockquote>level 1</blockquote><div>level 0</div></div>",
+			"level 0\n> \n> level 1\n> \n>> level 2\n> \n> level 1\n\nlevel 0",
+		},
+		{
+			"<blockquote>Test</blockquote>Test",
+			"> \n> Test\n\nTest",
+		},
+		{
+			"\t<blockquote> \nTest<br></blockquote> ",
+			"> \n> Test\n>",
+		},
+		{
+			"\t<blockquote> \nTest line 1<br>Test 2</blockquote> ",
+			"> \n> Test line 1\n> Test 2",
+		},
+		{
+			"<blockquote>Test</blockquote> <blockquote>Test</blockquote> Other Test",
+			"> \n> Test\n\n> \n> Test\n\nOther Test",
+		},
+		{
+			"<blockquote>Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua ad sit consequat quis ex commodo Duis incididunt eu mollit consectetur fugiat voluptate dolore in pariatur in commodo occaecat Ut occaecat velit esse labore aute quis commodo non sit dolore officia Excepteur cillum amet cupidatat culpa velit labore ullamco dolore mollit elit in aliqua dolor irure do</blockquote>",
+			"> \n> Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua ad\n> sit consequat quis ex commodo Duis incididunt eu mollit consectetur fugiat\n> voluptate dolore in pariatur in commodo occaecat Ut occaecat velit esse\n> labore aute quis commodo non sit dolore officia Excepteur cillum amet\n> cupidatat culpa velit labore ullamco dolore mollit elit in aliqua dolor\n> irure do",
+		},
+		{
+			"<blockquote>Lorem<b>ipsum</b><b>Commodo</b><b>id</b><b>consectetur</b><b>pariatur</b><b>ea</b><b>occaecat</b><b>minim</b><b>aliqua</b><b>ad</b><b>sit</b><b>consequat</b><b>quis</b><b>ex</b><b>commodo</b><b>Duis</b><b>incididunt</b><b>eu</b><b>mollit</b><b>consectetur</b><b>fugiat</b><b>voluptate</b><b>dolore</b><b>in</b><b>pariatur</b><b>in</b><b>commodo</b><b>occaecat</b><b>Ut</b><b>occaecat</b><b>velit</b><b>esse</b><b>labore</b><b>aute</b><b>quis</b><b>commodo</b><b>non</b><b>sit</b><b>dolore</b><b>officia</b><b>Excepteur</b><b>cillum</b><b>amet</b><b>cupidatat</b><b>culpa</b><b>velit</b><b>labore</b><b>ullamco</b><b>dolore</b><b>mollit</b><b>elit</b><b>in</b><b>aliqua</b><b>dolor</b><b>irure</b><b>do</b></blockquote>",
+			"> \n> Lorem*ipsum**Commodo**id**consectetur**pariatur**ea**occaecat**minim**aliqua*\n> *ad**sit**consequat**quis**ex**commodo**Duis**incididunt**eu**mollit**consectetur*\n> *fugiat**voluptate**dolore**in**pariatur**in**commodo**occaecat**Ut**occaecat*\n> *velit**esse**labore**aute**quis**commodo**non**sit**dolore**officia**Excepteur*\n> *cillum**amet**cupidatat**culpa**velit**labore**ullamco**dolore**mollit**elit*\n> *in**aliqua**dolor**irure**do*",
+		},
+	}
 
 	for _, testCase := range testCases {
 		if msg, err := wantString(testCase.input, testCase.output); err != nil {
@@ -881,6 +2254,274 @@ func TestPeriod(t *testing.T) {
 	}
 }
 
+func TestMarkdown(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<h1>Test</h1>",
+			"# Test",
+		},
+		{
+			"<h3>Test line 1<br>Test 2</h3>",
+			"### Test line 1 Test 2",
+		},
+		{
+			"<h6>Test</h6>",
+			"###### Test",
+		},
+		{
+			"<b>Test</b> <strong>Test</strong>",
+			"**Test** **Test**",
+		},
+		{
+			"<i>Test</i> <em>Test</em>",
+			"_Test_ _Test_",
+		},
+		{
+			"<ul><li><p>a</p></li></ul>",
+			"- a",
+		},
+		{
+			"a<br>b",
+			"a\\\nb",
+		},
+		{
+			"<p>a<br></p><p>b<br><br>c</p>",
+			"a\n\nb\n\nc",
+		},
+		{
+			"<ul><li>a<br>b</li></ul>",
+			"- a\\\n  b",
+		},
+		{
+			"<p>1. not a list *star*</p><p># not a heading</p>",
+			"1\\. not a list \\*star\\*\n\n\\# not a heading",
+		},
+		{
+			"<ul><li>1. item</li></ul>",
+			"- 1\\. item",
+		},
+		{
+			"<p>snake_case [x]</p> <code>a*b_c</code>",
+			"snake\\_case \\[x\\]\n\n`a*b_c`",
+		},
+		{
+			"<p>Use &lt;script&gt; tags &amp; &lt;b&gt;bold&lt;/b&gt;</p><p>AT&amp;T &amp;copy;</p>",
+			"Use \\<script> tags \\& \\<b>bold\\</b>\n\nAT\\&T \\&copy;",
+		},
+		{
+			"Wow!<a href='http://example.com/'>link</a> Done!",
+			"Wow\\![link](http://example.com/) Done!",
+		},
+		{
+			"<a href='http://example.com/'><img alt='a]b'></a>",
+			"[a\\]b](http://example.com/)",
+		},
+		{
+			"<a href='http://example.com/a b(c)'>Test</a>",
+			"[Test](http://example.com/a%20b%28c%29)",
+		},
+		{
+			"<ul><li><p>a</p><p>b</p></li><li>c</li></ul>",
+			"- a\n\n  b\n\n- c",
+		},
+		{
+			`<a href="http://example.com/">Link</a>`,
+			`[Link](http://example.com/)`,
+		},
+		{
+			`<a href="http://example.com/">http://example.com/</a>`,
+			`<http://example.com/>`,
+		},
+		{
+			`<a href="mailto:contact@example.org">Contact Us</a>`,
+			`[Contact Us](mailto:contact@example.org)`,
+		},
+		{
+			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"></a>`,
+			`[Example](http://example.com/)`,
+		},
+		{
+			"<ul><li>item 1</li><li>item 2</li></ul>",
+			"- item 1\n- item 2",
+		},
+		{
+			"<ol><li>item 1</li><li>item 2</li></ol>",
+			"1. item 1\n2. item 2",
+		},
+		{
+			"<blockquote>Test</blockquote>Test",
+			"> \n> Test\n\nTest",
+		},
+		{
+			"<p>Test</p><pre>test1\ntest 2</pre><p>Test</p>",
+			"Test\n\n```\ntest1\ntest 2\n```\n\nTest",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{Format: FormatMarkdown}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	// TextOnly takes precedence over the Markdown format.
+	if msg, err := wantString("<h1>Test</h1><b>Bold</b>", "Test.\n\nBold.", Options{Format: FormatMarkdown, TextOnly: true}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+}
+
+func TestLineWidth(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<p>Lorem ipsum dolor sit amet, consectetur adipiscing elit.</p>",
+			"Lorem ipsum dolor sit amet,\nconsectetur adipiscing elit.",
+		},
+		{
+			"<p>Lorem <b>ipsum dolor</b> sit amet, consectetur adipiscing elit.</p>",
+			"Lorem *ipsum dolor* sit amet,\nconsectetur adipiscing elit.",
+		},
+		{
+			"<p>Lorem ipsum Supercalifragilisticexpialidocious-and-then-some</p>",
+			"Lorem ipsum\nSupercalifragilisticexpialidoc\nious-and-then-some",
+		},
+		{
+			"<h1>Lorem ipsum dolor sit amet, consectetur</h1>",
+			"***************************\nLorem ipsum dolor sit amet,\nconsectetur\n***************************",
+		},
+		{
+			"<ul><li>Lorem ipsum dolor sit amet, consectetur adipiscing elit.<ol><li>Lorem ipsum dolor sit amet.</li></ol></li></ul>",
+			"* Lorem ipsum dolor sit amet,\n  consectetur adipiscing elit.\n  1. Lorem ipsum dolor sit\n     amet.",
+		},
+		{
+			"<blockquote>Lorem ipsum dolor sit amet, consectetur adipiscing elit.</blockquote>",
+			"> \n> Lorem ipsum dolor sit amet,\n> consectetur adipiscing elit.",
+		},
+		{
+			"<pre>Lorem ipsum dolor sit amet, consectetur adipiscing elit.</pre>",
+			"Lorem ipsum dolor sit amet, consectetur adipiscing elit.",
+		},
+		{
+			"<table><tr><td>Lorem ipsum dolor sit amet,</td><td>consectetur adipiscing elit.</td></tr></table>",
+			"Lorem ipsum dolor sit amet,\nconsectetur adipiscing elit.",
+		},
+		{
+			"<p>Lorem ipsum dolor sit <a href=\"http://example.com/\">amet</a> elit.</p>",
+			"Lorem ipsum dolor sit amet\n( http://example.com/ ) elit.",
+		},
+		{
+			"<p>日本語の文章 日本語の文章 日本語の文章</p>",
+			"日本語の文章 日本語の文章\n日本語の文章",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{LineWidth: 30}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	markdownCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<p>aaaa bbbb cccc dddd - eeee</p>",
+			"aaaa bbbb cccc dddd\n\\- eeee",
+		},
+		{
+			"<p>aaaa bbbb cccc dddd 1. eeee</p>",
+			"aaaa bbbb cccc dddd\n1\\. eeee",
+		},
+		{
+			"<p>aaaa bbbb cccc dddd # eeee</p>",
+			"aaaa bbbb cccc dddd\n\\# eeee",
+		},
+		{
+			"<p><a href=\"http://example.com/x\">link text here</a></p>",
+			"[link text\nhere](http://example.com/x)",
+		},
+	}
+
+	for _, testCase := range markdownCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{Format: FormatMarkdown, LineWidth: 20}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestElementHandlers(t *testing.T) {
+	options := Options{
+		ElementHandlers: map[string]ElementHandler{
+			"x-callout": func(w *ElementWriter, node *html.Node) error {
+				if err := w.Emit("\n\nNOTE:"); err != nil {
+					return err
+				}
+				w.Space()
+				if err := w.RenderChildren(node); err != nil {
+					return err
+				}
+				return w.Emit("\n\n")
+			},
+			"img": func(w *ElementWriter, node *html.Node) error {
+				for _, attr := range node.Attr {
+					if attr.Key == "alt" {
+						return w.Emit("[" + attr.Val + "]")
+					}
+				}
+				return nil
+			},
+			"a": func(w *ElementWriter, node *html.Node) error {
+				for _, attr := range node.Attr {
+					if attr.Key == "class" && attr.Val == "button" {
+						return w.RenderChildren(node)
+					}
+				}
+				return w.Default(node)
+			},
+		},
+	}
+
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<p>Test</p><x-callout>Mind the <b>gap</b></x-callout><p>Test</p>",
+			"Test\n\nNOTE: Mind the *gap*\n\nTest",
+		},
+		{
+			`<p>Logo: <img src="logo.png" alt="Example"></p>`,
+			"Logo: [Example]",
+		},
+		{
+			`<a class="button" href="http://example.com/">Sign up</a> <a href="http://example.com/">Link</a>`,
+			"Sign up Link ( http://example.com/ )",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
 type StringMatcher interface {
 	MatchString(string) bool
 	String() string